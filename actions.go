@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/skratchdot/open-golang/open"
+)
+
+// itemAction is something the user can do with a single row of the download list, through the row's menu or a
+// keyboard shortcut acting on the selected row.
+type itemAction struct {
+	label    string
+	icon     fyne.Resource
+	shortcut *desktop.CustomShortcut
+	enabled  func(item itemSnapshot) bool
+	run      func(appState *appState, item itemSnapshot)
+}
+
+var itemActions = []itemAction{
+	{
+		label:    "Open video",
+		icon:     theme.MediaPlayIcon(),
+		shortcut: &desktop.CustomShortcut{KeyName: fyne.KeyO, Modifier: fyne.KeyModifierShortcutDefault},
+		enabled:  isDownloaded,
+		run: func(appState *appState, item itemSnapshot) {
+			if err := open.Start(item.Path); err != nil {
+				logger.Errorf("Failed to open %s: %v\n", item.Path, err)
+			}
+		},
+	},
+	{
+		label:    "Show in folder",
+		icon:     theme.FolderOpenIcon(),
+		shortcut: &desktop.CustomShortcut{KeyName: fyne.KeyE, Modifier: fyne.KeyModifierShortcutDefault},
+		enabled:  isDownloaded,
+		run: func(appState *appState, item itemSnapshot) {
+			if err := revealInFolder(item.Path); err != nil {
+				logger.Errorf("Failed to show %s in its folder: %v\n", item.Path, err)
+			}
+		},
+	},
+	{
+		label:    "Retry / download again",
+		icon:     theme.ViewRefreshIcon(),
+		shortcut: &desktop.CustomShortcut{KeyName: fyne.KeyR, Modifier: fyne.KeyModifierShortcutDefault},
+		enabled:  isFinished,
+		run: func(appState *appState, item itemSnapshot) {
+			retryDownload(appState, item.Index)
+		},
+	},
+	{
+		label:    "Download next",
+		icon:     theme.MoveUpIcon(),
+		shortcut: &desktop.CustomShortcut{KeyName: fyne.KeyUp, Modifier: fyne.KeyModifierShortcutDefault},
+		enabled: func(item itemSnapshot) bool {
+			return item.Status == statusQueued
+		},
+		run: func(appState *appState, item itemSnapshot) {
+			prioritizeDownload(appState, item.Index)
+		},
+	},
+	{
+		label:    "Cancel",
+		icon:     theme.CancelIcon(),
+		shortcut: &desktop.CustomShortcut{KeyName: fyne.KeyBackspace, Modifier: fyne.KeyModifierShortcutDefault},
+		enabled: func(item itemSnapshot) bool {
+			return !isFinished(item)
+		},
+		run: func(appState *appState, item itemSnapshot) {
+			cancelDownload(appState, item.Index)
+		},
+	},
+	{
+		label:    "Copy link",
+		icon:     theme.ContentCopyIcon(),
+		shortcut: &desktop.CustomShortcut{KeyName: fyne.KeyL, Modifier: fyne.KeyModifierShortcutDefault},
+		enabled: func(item itemSnapshot) bool {
+			return item.Link != ""
+		},
+		run: func(appState *appState, item itemSnapshot) {
+			appState.window.Clipboard().SetContent(item.Link)
+		},
+	},
+	{
+		label:    "Copy error",
+		icon:     theme.ContentCopyIcon(),
+		shortcut: &desktop.CustomShortcut{KeyName: fyne.KeyC, Modifier: fyne.KeyModifierShortcutDefault | fyne.KeyModifierShift},
+		enabled: func(item itemSnapshot) bool {
+			return item.Error != ""
+		},
+		run: func(appState *appState, item itemSnapshot) {
+			appState.window.Clipboard().SetContent(errorReport(item))
+		},
+	},
+	{
+		label:    "Show log",
+		icon:     theme.DocumentIcon(),
+		shortcut: &desktop.CustomShortcut{KeyName: fyne.KeyL, Modifier: fyne.KeyModifierShortcutDefault | fyne.KeyModifierShift},
+		enabled: func(item itemSnapshot) bool {
+			return item.Status != statusQueued
+		},
+		run: func(appState *appState, item itemSnapshot) {
+			showLogViewer(appState, item.Name)
+		},
+	},
+}
+
+func isDownloaded(item itemSnapshot) bool {
+	return item.Status == statusSucceeded || item.Status == statusSkipped
+}
+
+func isFinished(item itemSnapshot) bool {
+	return item.Status != statusQueued && item.Status != statusInProgress
+}
+
+// errorReport describes a failed item in a form suitable for pasting into a bug report.
+func errorReport(item itemSnapshot) string {
+	return fmt.Sprintf("%s (%s): %s", item.Name, item.Link, item.Error)
+}
+
+func newItemMenu(appState *appState, item itemSnapshot) *fyne.Menu {
+	menuItems := make([]*fyne.MenuItem, len(itemActions))
+	for i, action := range itemActions {
+		action := action
+		menuItems[i] = &fyne.MenuItem{
+			Label:    action.label,
+			Icon:     action.icon,
+			Shortcut: action.shortcut,
+			Disabled: !action.enabled(item),
+			Action: func() {
+				action.run(appState, item)
+			},
+		}
+	}
+	return fyne.NewMenu("", menuItems...)
+}
+
+func showItemMenu(appState *appState, item itemSnapshot, pos fyne.Position) {
+	widget.ShowPopUpMenuAtPosition(newItemMenu(appState, item), appState.window.Canvas(), pos)
+}
+
+// registerItemShortcuts makes the keyboard shortcuts of the item actions act on the selected row.
+func registerItemShortcuts(appState *appState) {
+	for _, action := range itemActions {
+		action := action
+		appState.window.Canvas().AddShortcut(action.shortcut, func(fyne.Shortcut) {
+			item, ok := appState.downloads.selectedItem()
+			if ok && action.enabled(item) {
+				action.run(appState, item)
+			}
+		})
+	}
+}
+
+// revealInFolder opens the folder containing path in the system's file manager, selecting the file where supported.
+func revealInFolder(path string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", "-R", path).Start()
+	case "windows":
+		return exec.Command("explorer", "/select,"+path).Start()
+	default:
+		return open.Start(filepath.Dir(path))
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/ncruces/zenity"
+)
+
+// Categories of problems found by an audit.
+const (
+	auditMissing      = "missing"
+	auditExtra        = "extra"
+	auditCorrupt      = "corrupt"
+	auditOrphanedTemp = "orphaned temp"
+	auditDuplicate    = "duplicate"
+)
+
+// auditReport compares an export, the manifest and the files in an output directory, to prove that an archive is
+// complete (or show what's wrong with it).
+type auditReport struct {
+	InputFile   string    `json:"inputFile"`
+	OutputDir   string    `json:"outputDir"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Expected    int       `json:"expected"` // Number of videos in the export.
+	Present     int       `json:"present"`  // Number of them that are on disk and intact.
+
+	Missing      []auditEntry `json:"missing"`
+	Extra        []auditEntry `json:"extra"`
+	Corrupt      []auditEntry `json:"corrupt"`
+	OrphanedTemp []auditEntry `json:"orphanedTemp"`
+	Duplicates   []auditEntry `json:"duplicates"`
+}
+
+type auditEntry struct {
+	Name   string `json:"name"`
+	Link   string `json:"link,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// auditArchive audits the output directory dir against the links of an export.
+func auditArchive(inputFile string, links []VideoLink, dir string, template string) (*auditReport, error) {
+	report := &auditReport{
+		InputFile:   inputFile,
+		OutputDir:   dir,
+		GeneratedAt: time.Now(),
+		Expected:    len(links),
+	}
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := map[string]string{}
+	hash := func(name string) (string, error) {
+		if h, ok := hashes[name]; ok {
+			return h, nil
+		}
+		h, err := hashFile(filepath.Join(dir, name))
+		hashes[name] = h
+		return h, err
+	}
+
+	// Videos of the export
+	expected := map[string]bool{}
+	for _, link := range links {
+		name := videoName(link, template)
+		if expected[name] {
+			report.Duplicates = append(report.Duplicates, auditEntry{Name: name, Link: link.Link, Detail: "listed more than once in the export"})
+			continue
+		}
+		expected[name] = true
+		info, ok := files[name]
+		if !ok {
+			report.Missing = append(report.Missing, auditEntry{Name: name, Link: link.Link})
+			continue
+		}
+		if reason := auditVideo(dir, name, info, manifest, hash); reason != "" {
+			report.Corrupt = append(report.Corrupt, auditEntry{Name: name, Link: link.Link, Size: info.Size(), Detail: reason})
+			continue
+		}
+		report.Present++
+	}
+
+	// Files that aren't in the export
+	for name, info := range files {
+		if expected[name] {
+			continue
+		}
+		if strings.HasSuffix(name, ".json") && expected[strings.TrimSuffix(name, ".json")+".mp4"] {
+			// Sidecar of a video
+			continue
+		}
+		if strings.HasSuffix(name, ".temp") {
+			report.OrphanedTemp = append(report.OrphanedTemp, auditEntry{Name: name, Size: info.Size()})
+			continue
+		}
+		entry := auditEntry{Name: name, Size: info.Size(), Detail: "unknown file"}
+		if manifestEntry, ok := manifest.get(name); ok {
+			entry.Link = manifestEntry.Link
+			entry.Detail = "not in the export, but downloaded by an earlier run"
+		}
+		report.Extra = append(report.Extra, entry)
+		if strings.EqualFold(filepath.Ext(name), ".mp4") {
+			if reason := auditVideo(dir, name, info, manifest, hash); reason != "" {
+				report.Corrupt = append(report.Corrupt, auditEntry{Name: name, Size: info.Size(), Detail: reason})
+			}
+		}
+	}
+
+	// Files with identical contents. Only files of the same size need to be hashed.
+	bySize := map[int64][]string{}
+	for name, info := range files {
+		if !strings.HasSuffix(name, ".temp") && info.Size() > 0 {
+			bySize[info.Size()] = append(bySize[info.Size()], name)
+		}
+	}
+	for size, names := range bySize {
+		if len(names) < 2 {
+			continue
+		}
+		byHash := map[string][]string{}
+		for _, name := range names {
+			h, err := hash(name)
+			if err != nil {
+				continue
+			}
+			byHash[h] = append(byHash[h], name)
+		}
+		for _, same := range byHash {
+			if len(same) < 2 {
+				continue
+			}
+			sort.Strings(same)
+			for _, name := range same[1:] {
+				report.Duplicates = append(report.Duplicates, auditEntry{Name: name, Size: size, Detail: fmt.Sprintf("same contents as %s", same[0])})
+			}
+		}
+	}
+
+	for _, entries := range [][]auditEntry{report.Missing, report.Extra, report.Corrupt, report.OrphanedTemp, report.Duplicates} {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name < entries[j].Name
+		})
+	}
+	return report, nil
+}
+
+// listFiles returns the files in dir and its account folders, by their path relative to dir with forward slashes.
+// The files kept by TikTok Archiver itself are left out.
+func listFiles(dir string) (map[string]os.FileInfo, error) {
+	files := map[string]os.FileInfo{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == manifestFileName || name == catalogFileName || isGalleryPage(name) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files[name] = info
+		return nil
+	})
+	return files, err
+}
+
+// auditVideo checks a single video on disk, returning why it's corrupt or "" if it's fine.
+func auditVideo(dir, name string, info os.FileInfo, manifest *manifest, hash func(string) (string, error)) string {
+	manifestEntry, inManifest := manifest.get(name)
+	if inManifest && manifestEntry.Size != info.Size() {
+		return fmt.Sprintf("file is %d bytes, but the manifest says %d", info.Size(), manifestEntry.Size)
+	}
+	if err := verifyMP4(filepath.Join(dir, filepath.FromSlash(name)), 0); err != nil {
+		return err.Error()
+	}
+	if inManifest && manifestEntry.SHA256 != "" {
+		h, err := hash(name)
+		if err != nil {
+			return fmt.Sprintf("failed to read file: %v", err)
+		}
+		if h != manifestEntry.SHA256 {
+			return "checksum doesn't match the manifest"
+		}
+	}
+	return ""
+}
+
+// complete tells whether every video of the export is on disk and intact.
+func (r *auditReport) complete() bool {
+	return len(r.Missing) == 0 && len(r.Corrupt) == 0
+}
+
+func (r *auditReport) summary() string {
+	status := "The archive is complete."
+	if !r.complete() {
+		status = "The archive is NOT complete."
+	}
+	return fmt.Sprintf("%s\n%d of %d videos present. %d missing, %d corrupt, %d extra files, %d orphaned temp files, %d duplicates.",
+		status, r.Present, r.Expected, len(r.Missing), len(r.Corrupt), len(r.Extra), len(r.OrphanedTemp), len(r.Duplicates))
+}
+
+// auditRow is a problem found by an audit, along with its category.
+type auditRow struct {
+	Category string
+	auditEntry
+}
+
+// rows returns every problem found by the audit.
+func (r *auditReport) rows() []auditRow {
+	var rows []auditRow
+	for _, category := range []struct {
+		name    string
+		entries []auditEntry
+	}{
+		{auditMissing, r.Missing},
+		{auditCorrupt, r.Corrupt},
+		{auditExtra, r.Extra},
+		{auditOrphanedTemp, r.OrphanedTemp},
+		{auditDuplicate, r.Duplicates},
+	} {
+		for _, entry := range category.entries {
+			rows = append(rows, auditRow{Category: category.name, auditEntry: entry})
+		}
+	}
+	return rows
+}
+
+func (r *auditReport) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func (r *auditReport) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"category", "name", "link", "size", "detail"}); err != nil {
+		return err
+	}
+	for _, row := range r.rows() {
+		if err := writer.Write([]string{row.Category, row.Name, row.Link, strconv.FormatInt(row.Size, 10), row.Detail}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeFile writes the report to path, as CSV or JSON.
+func (r *auditReport) writeFile(path string, asCSV bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if asCSV {
+		err = r.writeCSV(f)
+	} else {
+		err = r.writeJSON(f)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// showAudit audits the output directory against the input file, and shows the report.
+func showAudit(appState *appState) {
+	inputs := appState.inputs()
+	outputDir, _ := appState.outputDir.Get()
+	if outputDir == "" {
+		dialog.ShowError(fmt.Errorf("You must select a folder to audit."), appState.window)
+		return
+	}
+	progress := dialog.NewProgressInfinite("Audit archive", fmt.Sprintf("Auditing %s...", outputDir), appState.window)
+	progress.Show()
+	go func() {
+		report, err := func() (*auditReport, error) {
+			links, _, err := readExports(inputs)
+			if err != nil {
+				return nil, err
+			}
+			var inputFiles []string
+			for _, input := range inputs {
+				inputFiles = append(inputFiles, input.Path)
+			}
+			return auditArchive(strings.Join(inputFiles, ", "), links, outputDir, appState.jobOptions().fileNames)
+		}()
+		progress.Hide()
+		if err != nil {
+			logger.Errorf("Failed to audit archive: %v\n", err)
+			dialog.ShowError(err, appState.window)
+			return
+		}
+		logger.Infof("Audited %s: %s\n", outputDir, report.summary())
+
+		rows := report.rows()
+		list := widget.NewList(
+			func() int {
+				return len(rows)
+			},
+			func() fyne.CanvasObject {
+				return widget.NewLabel("")
+			},
+			func(id widget.ListItemID, obj fyne.CanvasObject) {
+				row := rows[id]
+				text := fmt.Sprintf("[%s] %s", row.Category, row.Name)
+				if row.Detail != "" {
+					text += ": " + row.Detail
+				}
+				obj.(*widget.Label).SetText(text)
+			},
+		)
+		summary := widget.NewLabel(report.summary())
+		summary.Wrapping = fyne.TextWrapWord
+		exportButton := widget.NewButton("Export report...", func() {
+			path, err := zenity.SelectFileSave(
+				zenity.Title("Save audit report"),
+				zenity.Filename("audit.json"),
+				zenity.ConfirmOverwrite(),
+				zenity.FileFilters{
+					{Name: "JSON files", Patterns: []string{"*.json"}, CaseFold: false},
+					{Name: "CSV files", Patterns: []string{"*.csv"}, CaseFold: false},
+				},
+			)
+			if err != nil {
+				if err != zenity.ErrCanceled {
+					logger.Errorf("Error selecting file: %v", err)
+				}
+				return
+			}
+			if err := report.writeFile(path, strings.EqualFold(filepath.Ext(path), ".csv")); err != nil {
+				logger.Errorf("Failed to export audit report: %v\n", err)
+				dialog.ShowError(err, appState.window)
+			}
+		})
+		content := container.NewBorder(summary, container.NewHBox(exportButton), nil, nil, list)
+		d := dialog.NewCustom("Audit archive", "Close", content, appState.window)
+		d.Resize(fyne.NewSize(700, 500))
+		d.Show()
+	}()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// catalogFileName is the name of the catalog kept in each output directory.
+const catalogFileName = "tiktok-archiver-catalog.json"
+
+// catalog keeps track of every post of every export that was downloaded into an output directory, so that later
+// exports only need their new posts downloaded, and posts that disappeared from TikTok can be told apart. Exports of
+// several accounts can share a catalog.
+type catalog struct {
+	path string
+
+	Version int                     `json:"version"`
+	Exports []catalogExport         `json:"exports"`
+	Posts   map[string]*catalogPost `json:"posts"` // By postKey.
+}
+
+// catalogExport is an export file that was imported into the catalog.
+type catalogExport struct {
+	ID         string    `json:"id"` // SHA-256 of the export file.
+	Path       string    `json:"path"`
+	Account    string    `json:"account,omitempty"`
+	ExportedAt time.Time `json:"exportedAt"` // Modification time of the export file.
+	ImportedAt time.Time `json:"importedAt"`
+	Posts      int       `json:"posts"`
+}
+
+type catalogPost struct {
+	Date     string   `json:"date"`
+	Link     string   `json:"link"` // As of the most recent export it's in.
+	Account  string   `json:"account,omitempty"`
+	FileName string   `json:"fileName"` // Relative to the output directory, see videoName.
+	Exports  []string `json:"exports"`  // IDs of the exports it's in.
+	// Metadata holds the other fields of the post, as of the most recent export it's in.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	FirstSeen time.Time `json:"firstSeen"` // Date of the oldest export it's in.
+	LastSeen  time.Time `json:"lastSeen"`  // Date of the newest export it's in.
+	// Deleted is set when the post isn't in the newest export of its account, i.e. it was deleted or made private
+	// since. Its video is kept.
+	Deleted bool `json:"deleted"`
+}
+
+// syncResult describes how an export differs from the ones imported before it.
+type syncResult struct {
+	New         []VideoLink    // Posts that weren't in any earlier export.
+	Deleted     []*catalogPost // Posts that just disappeared from the newest export of their account.
+	Reappeared  []*catalogPost // Posts that were deleted, but are back in the newest export of their account.
+	OlderExport bool           // Whether an export is older than one of the same account that was already imported.
+}
+
+var videoIDPattern = regexp.MustCompile(`/video/(\d+)`)
+
+// postKey identifies a post across exports. Links are signed and change with every export, so the video ID in the
+// link is used if there is one, and the account and post date otherwise.
+func postKey(link VideoLink) string {
+	if match := videoIDPattern.FindStringSubmatch(link.Link); match != nil {
+		return "id:" + match[1]
+	}
+	if link.Account != "" {
+		return "date:" + link.Account + "/" + link.Date
+	}
+	return "date:" + link.Date
+}
+
+// loadCatalog reads the catalog of an output directory, returning an empty one if there isn't any yet.
+func loadCatalog(dir string) (*catalog, error) {
+	c := &catalog{
+		path:    filepath.Join(dir, catalogFileName),
+		Version: 1,
+		Posts:   map[string]*catalogPost{},
+	}
+	content, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(content, c); err != nil {
+		return c, fmt.Errorf("failed to parse catalog: %v", err)
+	}
+	if c.Posts == nil {
+		c.Posts = map[string]*catalogPost{}
+	}
+	return c, nil
+}
+
+func (c *catalog) save() error {
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempPath := c.path + ".temp"
+	if err := os.WriteFile(tempPath, content, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, c.path)
+}
+
+// importExport adds the posts of an export file to the catalog, and tells whether the export is older than one of the
+// same account imported before. Importing the same export again is harmless. Unless the export is older, its posts'
+// links, metadata and file names (with template, see videoName) replace those imported before.
+func (c *catalog) importExport(export parsedExport, template string) (olderExport bool, err error) {
+	id, err := hashFile(export.Path)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(export.Path)
+	if err != nil {
+		return false, err
+	}
+	entry := catalogExport{
+		ID:         id,
+		Path:       export.Path,
+		Account:    export.Account,
+		ExportedAt: info.ModTime(),
+		ImportedAt: time.Now(),
+		Posts:      len(export.Links),
+	}
+	found := false
+	for i, existing := range c.Exports {
+		if existing.ID == id {
+			entry.ExportedAt = existing.ExportedAt
+			c.Exports[i] = entry
+			found = true
+		} else if existing.Account == entry.Account && existing.ExportedAt.After(entry.ExportedAt) {
+			olderExport = true
+		}
+	}
+	if !found {
+		c.Exports = append(c.Exports, entry)
+	}
+	sort.SliceStable(c.Exports, func(i, j int) bool {
+		return c.Exports[i].ExportedAt.Before(c.Exports[j].ExportedAt)
+	})
+
+	for _, link := range export.Links {
+		key := postKey(link)
+		post, ok := c.Posts[key]
+		if !ok {
+			post = &catalogPost{Date: link.Date, Account: link.Account, FileName: videoName(link, template)}
+			c.Posts[key] = post
+		}
+		if !post.inExport(id) {
+			post.Exports = append(post.Exports, id)
+		}
+		if !olderExport {
+			post.Link = unsignedLink(link.Link)
+			post.Metadata = link.Metadata
+			// The file name template may have changed since, and it's the one videos are downloaded with now.
+			post.FileName = videoName(link, template)
+		}
+	}
+	return olderExport, nil
+}
+
+// refresh recomputes the dates and deletion flags of every post from the exports it's in, returning the posts whose
+// deletion flag changed.
+func (c *catalog) refresh() (deleted, reappeared []*catalogPost) {
+	exportDates := map[string]time.Time{}
+	newest := map[string]string{} // ID of the newest export, by account.
+	for _, export := range c.Exports {
+		exportDates[export.ID] = export.ExportedAt
+		newest[export.Account] = export.ID
+	}
+	for _, post := range c.Posts {
+		post.FirstSeen, post.LastSeen = time.Time{}, time.Time{}
+		for _, id := range post.Exports {
+			date := exportDates[id]
+			if post.FirstSeen.IsZero() || date.Before(post.FirstSeen) {
+				post.FirstSeen = date
+			}
+			if date.After(post.LastSeen) {
+				post.LastSeen = date
+			}
+		}
+		isDeleted := !post.inExport(newest[post.Account])
+		if isDeleted && !post.Deleted {
+			deleted = append(deleted, post)
+		} else if !isDeleted && post.Deleted {
+			reappeared = append(reappeared, post)
+		}
+		post.Deleted = isDeleted
+	}
+	return deleted, reappeared
+}
+
+func (post *catalogPost) inExport(id string) bool {
+	for _, existing := range post.Exports {
+		if existing == id {
+			return true
+		}
+	}
+	return false
+}
+
+// syncExports imports exports into the catalog of the output directory and logs what changed. links are the merged
+// links of the exports. When onlyNew is set, it returns only the links of posts that weren't in any export imported
+// before, or whose video isn't on disk yet. Videos are named with template, see videoName.
+func syncExports(exports []parsedExport, links []VideoLink, outputDir string, onlyNew bool, template string) ([]VideoLink, syncResult, error) {
+	var result syncResult
+	c, err := loadCatalog(outputDir)
+	if err != nil {
+		return nil, result, err
+	}
+	known := map[string]bool{}
+	for _, export := range c.Exports {
+		known[export.ID] = true
+	}
+	for _, export := range exports {
+		olderExport, err := c.importExport(export, template)
+		if err != nil {
+			return nil, result, err
+		}
+		if olderExport {
+			logger.Warnf("%s is older than an export imported before, so it won't mark any post as deleted.\n", export.Path)
+			result.OlderExport = true
+		}
+	}
+	result.Deleted, result.Reappeared = c.refresh()
+	if err := c.save(); err != nil {
+		return nil, result, err
+	}
+
+	isNew := map[string]bool{}
+	for _, link := range links {
+		post := c.Posts[postKey(link)]
+		seenBefore := false
+		for _, id := range post.Exports {
+			seenBefore = seenBefore || known[id]
+		}
+		if !seenBefore {
+			result.New = append(result.New, link)
+			isNew[postKey(link)] = true
+		}
+	}
+	logger.Infof("Imported %d exports into the catalog: %d posts, %d new since earlier exports\n", len(exports), len(links), len(result.New))
+	for _, post := range result.Deleted {
+		logger.Warnf("%s is no longer in the newest export (deleted or made private). Keeping its video.\n", post.FileName)
+	}
+	for _, post := range result.Reappeared {
+		logger.Debugf("%s is back in the newest export.\n", post.FileName)
+	}
+	if !onlyNew {
+		return links, result, nil
+	}
+	var selected []VideoLink
+	for _, link := range links {
+		if _, err := os.Stat(videoPath(outputDir, link, template)); isNew[postKey(link)] || err != nil {
+			selected = append(selected, link)
+		}
+	}
+	logger.Infof("Downloading %d of %d posts, the others were already archived from earlier exports\n", len(selected), len(links))
+	return selected, result, nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/ncruces/zenity"
+)
+
+// catalogRow is a video of the download list, along with what the catalog and the file on disk tell about it.
+type catalogRow struct {
+	item     itemSnapshot
+	post     *catalogPost // nil if it isn't in the catalog.
+	size     int64        // -1 if the file isn't on disk.
+	duration time.Duration
+}
+
+// catalogColumn is a column that the catalog can be exported with.
+type catalogColumn struct {
+	Name  string // Header in CSV, and key in JSON.
+	Label string
+	value func(row catalogRow) interface{}
+}
+
+var catalogColumns = []catalogColumn{
+	{"date", "Date", func(row catalogRow) interface{} { return row.item.Date }},
+	{"link", "Link", func(row catalogRow) interface{} { return unsignedLink(row.item.Link) }},
+	{"likes", "Likes", func(row catalogRow) interface{} {
+		if likes, err := strconv.Atoi(strings.ReplaceAll(row.field("likes"), ",", "")); err == nil {
+			return likes
+		}
+		return nil
+	}},
+	{"fileName", "File name", func(row catalogRow) interface{} { return row.item.Name }},
+	{"size", "File size", func(row catalogRow) interface{} {
+		if row.size < 0 {
+			return nil
+		}
+		return row.size
+	}},
+	{"duration", "Duration", func(row catalogRow) interface{} {
+		if row.duration == 0 {
+			return nil
+		}
+		return row.duration.Seconds()
+	}},
+	{"status", "Status", func(row catalogRow) interface{} { return row.item.Status }},
+	{"error", "Error", func(row catalogRow) interface{} { return redact(row.item.Error) }},
+	{"account", "Account", func(row catalogRow) interface{} { return row.field("account") }},
+	{"caption", "Caption", func(row catalogRow) interface{} { return row.field("caption") }},
+	{"firstSeen", "First seen", func(row catalogRow) interface{} {
+		if row.post == nil {
+			return nil
+		}
+		return row.post.FirstSeen
+	}},
+	{"lastSeen", "Last seen", func(row catalogRow) interface{} {
+		if row.post == nil {
+			return nil
+		}
+		return row.post.LastSeen
+	}},
+	{"deleted", "Deleted from TikTok", func(row catalogRow) interface{} { return row.post != nil && row.post.Deleted }},
+}
+
+// defaultCatalogColumns are the columns selected until the user picks others.
+var defaultCatalogColumns = []string{"date", "link", "likes", "fileName", "size", "duration", "status", "error"}
+
+func (row catalogRow) field(name string) string {
+	if row.post == nil {
+		return ""
+	}
+	return VideoLink{Date: row.post.Date, Link: row.post.Link, Account: row.post.Account, Metadata: row.post.Metadata}.field(name)
+}
+
+// catalogRows gathers the videos of the catalog of outputDir, along with what the disk tells about them. When the
+// download list shows a run, its videos that aren't in the catalog yet (e.g. those that failed) are added, with their
+// status and error, and the list's filters and sort apply. Otherwise, every video of the catalog is kept, newest
+// first.
+func catalogRows(outputDir string, downloads *downloadState) ([]catalogRow, error) {
+	c, err := loadCatalog(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	byFileName := map[string]*catalogPost{}
+	for _, post := range c.Posts {
+		byFileName[post.FileName] = post
+	}
+	runItems := downloads.runItems()
+	inRun := map[string]itemSnapshot{}
+	for _, item := range runItems {
+		inRun[item.Name] = item
+	}
+
+	var items []itemSnapshot
+	for _, post := range c.Posts {
+		item, ok := inRun[post.FileName]
+		if !ok {
+			item = itemSnapshot{
+				Index: -1,
+				Name:  post.FileName,
+				Path:  filepath.Join(outputDir, filepath.FromSlash(post.FileName)),
+				Date:  post.Date,
+				Link:  post.Link,
+			}
+		}
+		items = append(items, item)
+	}
+	for _, item := range runItems {
+		if byFileName[item.Name] == nil {
+			items = append(items, item)
+		}
+	}
+	// Posts come from a map, so put them in a fixed order for the sorts below to be stable on.
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+	if len(runItems) > 0 {
+		items = downloads.arrange(items)
+	} else {
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].Date > items[j].Date
+		})
+	}
+
+	rows := make([]catalogRow, len(items))
+	for i, item := range items {
+		rows[i] = catalogRow{item: item, post: byFileName[item.Name], size: -1}
+		if info, err := os.Stat(item.Path); err == nil {
+			rows[i].size = info.Size()
+			if duration, err := mp4Duration(item.Path); err == nil {
+				rows[i].duration = duration
+			}
+		}
+	}
+	return rows, nil
+}
+
+func selectCatalogColumns(names []string) []catalogColumn {
+	var columns []catalogColumn
+	for _, column := range catalogColumns {
+		for _, name := range names {
+			if column.Name == name {
+				columns = append(columns, column)
+			}
+		}
+	}
+	return columns
+}
+
+func writeCatalogJSON(w io.Writer, rows []catalogRow, columns []catalogColumn) error {
+	objects := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		objects[i] = map[string]interface{}{}
+		for _, column := range columns {
+			objects[i][column.Name] = column.value(row)
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(objects)
+}
+
+// csvText keeps spreadsheets from running text as a formula, e.g. a caption starting with "=", by prefixing it with
+// an apostrophe, which spreadsheets hide.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func writeCatalogCSV(w io.Writer, rows []catalogRow, columns []catalogColumn) error {
+	writer := csv.NewWriter(w)
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		record[i] = column.Name
+	}
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		for i, column := range columns {
+			switch value := column.value(row).(type) {
+			case nil:
+				record[i] = ""
+			case time.Time:
+				record[i] = value.Format(time.RFC3339)
+			case float64:
+				record[i] = strconv.FormatFloat(value, 'f', 3, 64)
+			case string:
+				record[i] = csvText(value)
+			default:
+				record[i] = fmt.Sprint(value)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeCatalogFile writes rows to path, as CSV or JSON.
+func writeCatalogFile(path string, asCSV bool, rows []catalogRow, columns []catalogColumn) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if asCSV {
+		err = writeCatalogCSV(f, rows, columns)
+	} else {
+		err = writeCatalogJSON(f, rows, columns)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// showCatalogExport lets the user export the videos of the catalog of the output folder to CSV or JSON. When the
+// download list shows a run, the export is filtered and sorted like the list.
+func showCatalogExport(appState *appState) {
+	outputDir, _ := appState.outputDir.Get()
+	if outputDir == "" {
+		dialog.ShowError(fmt.Errorf("You must select an output folder."), appState.window)
+		return
+	}
+
+	var labels []string
+	selected := map[string]bool{}
+	for _, column := range catalogColumns {
+		labels = append(labels, column.Label)
+	}
+	saved, _ := appState.exportColumns.Get()
+	names := defaultCatalogColumns
+	if saved != "" {
+		names = strings.Split(saved, ",")
+	}
+	for _, column := range selectCatalogColumns(names) {
+		selected[column.Label] = true
+	}
+	columnsCheck := widget.NewCheckGroup(labels, nil)
+	for _, label := range labels {
+		if selected[label] {
+			columnsCheck.Selected = append(columnsCheck.Selected, label)
+		}
+	}
+
+	text := "Exports every video of the catalog of the output folder, with the columns below."
+	if len(appState.downloads.runItems()) > 0 {
+		text = "Exports the videos of the catalog of the output folder, and those of the download list, filtered and " +
+			"sorted like the list, with the columns below."
+	}
+	message := widget.NewLabel(text)
+	message.Wrapping = fyne.TextWrapWord
+	content := container.NewBorder(message, nil, nil, nil, container.NewVScroll(columnsCheck))
+	d := dialog.NewCustomConfirm("Export catalog", "Save...", "Cancel", content, func(ok bool) {
+		if !ok {
+			return
+		}
+		var names []string
+		for _, column := range catalogColumns {
+			for _, label := range columnsCheck.Selected {
+				if column.Label == label {
+					names = append(names, column.Name)
+				}
+			}
+		}
+		appState.exportColumns.Set(strings.Join(names, ","))
+		columns := selectCatalogColumns(names)
+		if len(columns) == 0 {
+			dialog.ShowError(fmt.Errorf("You must select at least one column."), appState.window)
+			return
+		}
+
+		path, err := zenity.SelectFileSave(
+			zenity.Title("Export catalog"),
+			zenity.Filename("catalog.csv"),
+			zenity.ConfirmOverwrite(),
+			zenity.FileFilters{
+				{Name: "CSV files", Patterns: []string{"*.csv"}, CaseFold: false},
+				{Name: "JSON files", Patterns: []string{"*.json"}, CaseFold: false},
+			},
+		)
+		if err != nil {
+			if err != zenity.ErrCanceled {
+				logger.Errorf("Error selecting file: %v", err)
+			}
+			return
+		}
+		go func() {
+			rows, err := catalogRows(outputDir, appState.downloads)
+			if err == nil && len(rows) == 0 {
+				err = fmt.Errorf("There's nothing to export. Download some videos first, or change the filters of the list.")
+			}
+			if err == nil {
+				err = writeCatalogFile(path, !strings.EqualFold(filepath.Ext(path), ".json"), rows, columns)
+			}
+			if err != nil {
+				logger.Errorf("Failed to export catalog: %v\n", err)
+				dialog.ShowError(err, appState.window)
+				return
+			}
+			logger.Infof("Exported %d videos to %s\n", len(rows), path)
+		}()
+	}, appState.window)
+	d.Resize(fyne.NewSize(400, 500))
+	d.Show()
+}
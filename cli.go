@@ -0,0 +1,424 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const usage = `Usage: tiktok-archiver [command] [options]
+
+Without a command, TikTok Archiver opens its window.
+
+Commands:
+  audit    Check that an output folder contains every video of an export
+  check    Read an export, and show its format and any problems in it
+  daemon   Run downloads in the background, controlled through a JSON API
+  tui      Download in the terminal, without the window
+  serve    Serve an output folder over HTTP, to browse it and get its catalog as JSON
+  help     Show this help
+
+Run "tiktok-archiver [command] -h" for the options of a command.
+`
+
+// isCommand tells whether the app was started from the command line with a command, rather than as a GUI app. (macOS
+// passes a -psn_... argument to apps launched from Finder.)
+func isCommand(args []string) bool {
+	return len(args) > 0 && !strings.HasPrefix(args[0], "-psn")
+}
+
+// runCommand runs a command-line command, and returns the process's exit code.
+func runCommand(args []string) int {
+	// Logs would get mixed up with the output of commands.
+	logger.SetOutput(os.Stderr)
+
+	switch args[0] {
+	case "audit":
+		return auditCommand(args[1:])
+	case "check":
+		return checkCommand(args[1:])
+	case "serve":
+		return serveCommand(args[1:])
+	case "daemon":
+		return daemonCommand(args[1:])
+	case "tui":
+		return tuiCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q.\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+// detectFileType guesses the type of an input file from its name, or returns "" if it can't tell.
+func detectFileType(path string) string {
+	switch strings.ToLower(filepath.Base(path)) {
+	case "posts.txt":
+		return "Posts.txt"
+	case "user_data.json":
+		return "user_data.json"
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".txt":
+		return "Posts.txt"
+	case ".json":
+		return "user_data.json"
+	}
+	return ""
+}
+
+// inputFlags registers the flags shared by every command that reads exports. -input can be repeated to read several
+// exports at once, and so can -type and -account, once per -input in the same order; given once, they apply to all of
+// them.
+func inputFlags(flags *flag.FlagSet) (inputs func() ([]exportInput, error), outputDir, fileNames *string) {
+	var paths, types, accounts []string
+	repeated := func(values *[]string) func(string) error {
+		return func(value string) error {
+			*values = append(*values, value)
+			return nil
+		}
+	}
+	flags.Func("input", "the Posts.txt or user_data.json file of a TikTok export (repeat it to read several exports)", repeated(&paths))
+	flags.Func("type", `the type of the input file, "Posts.txt" or "user_data.json" (default: guessed from its name)`, repeated(&types))
+	flags.Func("account", "the account the export is from, if its videos are in a folder of their own", repeated(&accounts))
+	outputDir = flags.String("output", "", "the folder the videos are downloaded to")
+	fileNames = new(string)
+	flags.Func("names", "the template the videos are named with (default \""+defaultFileNameTemplate+"\")", func(template string) error {
+		if err := validateFileNameTemplate(template); err != nil {
+			return err
+		}
+		*fileNames = template
+		return nil
+	})
+	inputs = func() ([]exportInput, error) {
+		for _, repeatable := range []struct {
+			name   string
+			values []string
+		}{{"-type", types}, {"-account", accounts}} {
+			if len(repeatable.values) > 1 && len(repeatable.values) != len(paths) {
+				return nil, fmt.Errorf("%s is given %d times for %d inputs. Give it once for all of them, or once per -input.",
+					repeatable.name, len(repeatable.values), len(paths))
+			}
+		}
+		// nth returns the value of values for the ith input.
+		nth := func(values []string, i int) string {
+			switch len(values) {
+			case 0:
+				return ""
+			case 1:
+				return values[0]
+			}
+			return values[i]
+		}
+		var result []exportInput
+		for i, path := range paths {
+			input := exportInput{Path: path, Type: nth(types, i), Account: nth(accounts, i)}
+			if input.Type == "" {
+				input.Type = detectFileType(path)
+			}
+			result = append(result, input)
+		}
+		return result, nil
+	}
+	return
+}
+
+// optionFlags registers the flags of the download options, with the same defaults as the daemon's.
+func optionFlags(flags *flag.FlagSet) *daemonOptions {
+	options := defaultDaemonOptions()
+	flags.BoolVar(&options.SkipExisting, "skip-existing", options.SkipExisting, "skip the videos that were already downloaded")
+	flags.StringVar(&options.SkipMode, "skip-mode", options.SkipMode, fmt.Sprintf("how to tell that a video was already downloaded: %q", skipModes))
+	flags.IntVar(&options.Parallelism, "parallelism", options.Parallelism, "the number of videos to download at a time")
+	flags.StringVar(&options.Order, "order", options.Order, fmt.Sprintf("the order to download the videos in: %q", downloadOrders))
+	flags.BoolVar(&options.OnlyNew, "only-new", options.OnlyNew, "only download the posts that are new since the last export")
+	flags.BoolVar(&options.WriteSidecars, "sidecars", options.WriteSidecars, "save each post's caption and details next to its video")
+	flags.BoolVar(&options.Gallery, "gallery", options.Gallery, "update the gallery once the downloads are done")
+	return &options
+}
+
+func auditCommand(args []string) int {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	logFlags(flags)
+	readInputs, outputDir, fileNames := inputFlags(flags)
+	jsonPath := flags.String("json", "", "also write the report as JSON to this file")
+	csvPath := flags.String("csv", "", "also write the report as CSV to this file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	inputs, err := readInputs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if len(inputs) == 0 || *outputDir == "" {
+		fmt.Fprintln(os.Stderr, "Both -input and -output are required.")
+		flags.Usage()
+		return 2
+	}
+
+	// Every export of the archive is read, so that the videos of the others don't count as extra.
+	links, _, err := readExports(inputs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var inputFiles []string
+	for _, input := range inputs {
+		inputFiles = append(inputFiles, input.Path)
+	}
+	report, err := auditArchive(strings.Join(inputFiles, ", "), links, *outputDir, *fileNames)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, row := range report.rows() {
+		fmt.Printf("%-14s %s", row.Category, row.Name)
+		if row.Detail != "" {
+			fmt.Printf(" (%s)", row.Detail)
+		}
+		fmt.Println()
+	}
+	fmt.Println(report.summary())
+	if *jsonPath != "" {
+		if err := report.writeFile(*jsonPath, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", *jsonPath, err)
+			return 1
+		}
+	}
+	if *csvPath != "" {
+		if err := report.writeFile(*csvPath, true); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", *csvPath, err)
+			return 1
+		}
+	}
+	if !report.complete() {
+		return 1
+	}
+	return 0
+}
+
+func checkCommand(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	logFlags(flags)
+	inputFile := flags.String("input", "", "the Posts.txt or user_data.json file of the TikTok export")
+	fileType := flags.String("type", "", `the type of the input file, "Posts.txt" or "user_data.json" (default: guessed from its name)`)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *inputFile == "" {
+		fmt.Fprintln(os.Stderr, "-input is required.")
+		flags.Usage()
+		return 2
+	}
+	if *fileType == "" {
+		*fileType = detectFileType(*inputFile)
+	}
+
+	links, report, err := readAndParseFile(*inputFile, *fileType)
+	if report != nil {
+		for _, warning := range report.Warnings {
+			fmt.Println(warning)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%d posts, %d warnings. Format: %s\n", len(links), len(report.Warnings), report.Schema)
+	return 0
+}
+
+func serveCommand(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	logFlags(flags)
+	outputDir := flags.String("output", "", "the folder the videos are downloaded to")
+	port := flags.Int("port", defaultServePort, "the port to serve on")
+	lan := flags.Bool("lan", false, "serve other devices on the network too, which requires a token")
+	token := flags.String("token", "", "the token that clients must give, as a token query parameter or a bearer token (default: random with -lan, none otherwise)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := serveDirExists(*outputDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return 2
+	}
+
+	server, err := startArchiveServer(*outputDir, *port, *lan, *token)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, address := range server.urls {
+		fmt.Println(address)
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	if err := server.stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func daemonCommand(args []string) int {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	logFlags(flags)
+	port := flags.Int("port", defaultDaemonPort, "the port to serve the API on")
+	lan := flags.Bool("lan", false, "serve other devices on the network too, which requires a token")
+	token := flags.String("token", "", "the token that clients must give, as a token query parameter or a bearer token (default: random with -lan, none otherwise)")
+	statePath := flags.String("state", "", "the file the jobs are kept in (default: daemon.json in the app's data folder)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *statePath == "" {
+		dataDir, err := appDataDir()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		*statePath = filepath.Join(dataDir, daemonStateFileName)
+	}
+	// The daemon runs unattended, so it logs to a file like the GUI does.
+	if newLogger, err := createLogger(os.Stderr); err != nil {
+		logger.Errorf("Failed to create logger: %v\n", err)
+	} else {
+		logger = newLogger
+	}
+
+	d, err := newDaemon(*statePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	host := "127.0.0.1"
+	if *lan {
+		host = ""
+		if *token == "" {
+			*token = newToken()
+		}
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(*port)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	server := &http.Server{Handler: d.handler(*token), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Daemon server stopped: %v\n", err)
+		}
+	}()
+	d.hub.subscribe(10*time.Second, logProgress)
+	go d.run()
+
+	hosts := []string{"localhost"}
+	if *lan {
+		hosts = append(hosts, lanAddresses()...)
+	}
+	for _, host := range hosts {
+		address := fmt.Sprintf("http://%s/api/jobs", net.JoinHostPort(host, strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)))
+		if *token != "" {
+			address += "?token=" + *token
+		}
+		fmt.Println(address)
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	d.lock.Lock()
+	// Jobs still running are picked up where they were the next time the daemon starts.
+	d.save()
+	d.lock.Unlock()
+	if err := server.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func tuiCommand(args []string) int {
+	flags := flag.NewFlagSet("tui", flag.ContinueOnError)
+	logFlags(flags)
+	readInputs, outputDir, fileNames := inputFlags(flags)
+	options := optionFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	inputs, err := readInputs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if len(inputs) == 0 || *outputDir == "" {
+		fmt.Fprintln(os.Stderr, "Both -input and -output are required.")
+		flags.Usage()
+		return 2
+	}
+	if *fileNames != "" {
+		options.FileNames = *fileNames
+	}
+	if err := options.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	links, exports, err := readExports(inputs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, export := range exports {
+		if export.Report != nil && len(export.Report.Warnings) > 0 {
+			fmt.Fprintf(os.Stderr, "%d posts of %s couldn't be read. Run \"tiktok-archiver check -input %s\" for details.\n",
+				len(export.Report.Warnings), export.Path, export.Path)
+		}
+	}
+	links, result, err := syncExports(exports, links, *outputDir, options.OnlyNew, options.FileNames)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(result.Deleted) > 0 {
+		fmt.Fprintf(os.Stderr, "%d posts from earlier exports aren't in this one anymore.\n", len(result.Deleted))
+	}
+	if len(links) == 0 {
+		fmt.Println("All of the videos are already downloaded.")
+		return 0
+	}
+
+	// Logs would mess the screen up, so they only go to the log file.
+	if newLogger, err := createLogger(io.Discard); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create logger: %v\n", err)
+		logger.SetOutput(io.Discard)
+	} else {
+		logger = newLogger
+	}
+	t := &tui{inputs: inputs, outputDir: *outputDir, options: *options, hub: newProgressHub(), downloads: newDownloadState()}
+	defer t.hub.close()
+	t.job = launchJob(t.hub, *outputDir, links, options.jobOptions(), func(*downloadJob) {
+		logger.Infof("All downloads completed.\n")
+		if options.Gallery {
+			if err := buildGallery(*outputDir); err != nil {
+				logger.Errorf("Failed to update the gallery: %v\n", err)
+			}
+		}
+		t.lock.Lock()
+		t.finished, t.paused = true, false
+		t.lock.Unlock()
+	})
+	return t.run()
+}
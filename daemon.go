@@ -0,0 +1,747 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The daemon downloads without the GUI, controlled through a JSON API. Jobs are submitted to it, run one at a time in
+// the order they were submitted, and are kept across restarts of the daemon.
+
+// defaultDaemonPort is the port the daemon's API is served on, unless another one is picked.
+const defaultDaemonPort = 8766
+
+// daemonStateFileName is the name of the file the daemon keeps its jobs in, in the app's data folder.
+const daemonStateFileName = "daemon.json"
+
+// States of a daemon job.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobPaused    = "paused"
+	jobFinished  = "finished"
+	jobCancelled = "cancelled"
+	jobFailed    = "failed" // It couldn't start, e.g. because an export couldn't be read.
+)
+
+// daemonOptions are the options of a job, the same as the GUI's.
+type daemonOptions struct {
+	SkipExisting  bool   `json:"skipExisting"`
+	SkipMode      string `json:"skipMode"`
+	Parallelism   int    `json:"parallelism"`
+	Order         string `json:"order"`
+	OnlyNew       bool   `json:"onlyNew"`
+	WriteSidecars bool   `json:"writeSidecars"`
+	FileNames     string `json:"fileNames"`
+	Gallery       bool   `json:"gallery"`
+}
+
+func defaultDaemonOptions() daemonOptions {
+	return daemonOptions{
+		SkipExisting: true,
+		SkipMode:     skipIfExists,
+		Parallelism:  8,
+		Order:        orderNewestFirst,
+		Gallery:      true,
+	}
+}
+
+func (o daemonOptions) validate() error {
+	if o.FileNames != "" {
+		if err := validateFileNameTemplate(o.FileNames); err != nil {
+			return err
+		}
+	}
+	if !contains(skipModes, o.SkipMode) {
+		return fmt.Errorf("skipMode must be one of %q", skipModes)
+	}
+	if !contains(downloadOrders, o.Order) {
+		return fmt.Errorf("order must be one of %q", downloadOrders)
+	}
+	if o.Parallelism < 1 || o.Parallelism > 16 {
+		return fmt.Errorf("parallelism must be between 1 and 16")
+	}
+	return nil
+}
+
+func (o daemonOptions) jobOptions() jobOptions {
+	return jobOptions{
+		skipExisting:  o.SkipExisting,
+		skipMode:      o.SkipMode,
+		parallelism:   o.Parallelism,
+		order:         o.Order,
+		writeSidecars: o.WriteSidecars,
+		fileNames:     o.FileNames,
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// daemonProgress is the progress of a job's latest run, as served by the API.
+type daemonProgress struct {
+	Total          int            `json:"total"`
+	Completed      int            `json:"completed"`
+	Errors         int            `json:"errors"`
+	Skipped        int            `json:"skipped"`
+	BytesDone      int64          `json:"bytesDone"`
+	BytesTotal     int64          `json:"bytesTotal"`
+	BytesPerSecond int64          `json:"bytesPerSecond"`
+	Fraction       float64        `json:"fraction"`
+	ETASeconds     float64        `json:"etaSeconds"`
+	Statuses       map[string]int `json:"statuses"`
+}
+
+func newDaemonProgress(c progressCounters) daemonProgress {
+	statuses := map[string]int{}
+	for i, status := range allStatuses {
+		statuses[status] = c.StatusCounts[i]
+	}
+	return daemonProgress{
+		Total:          c.Total,
+		Completed:      c.Completed,
+		Errors:         c.Errors,
+		Skipped:        c.Skipped,
+		BytesDone:      c.BytesDone,
+		BytesTotal:     c.BytesTotal,
+		BytesPerSecond: c.BytesPerSecond,
+		Fraction:       c.Fraction,
+		ETASeconds:     c.ETA.Seconds(),
+		Statuses:       statuses,
+	}
+}
+
+// daemonItem is a video of a job.
+type daemonItem struct {
+	Name          string `json:"name"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	BytesDone     int64  `json:"bytesDone"`
+	ContentLength int64  `json:"contentLength"`
+	Attempts      int    `json:"attempts"`
+}
+
+func newDaemonItem(s itemSnapshot) daemonItem {
+	return daemonItem{
+		Name:          s.Name,
+		Status:        s.Status,
+		Error:         redact(s.Error),
+		BytesDone:     s.BytesDone,
+		ContentLength: s.ContentLength,
+		Attempts:      s.Attempts,
+	}
+}
+
+// daemonJob is a download of the videos of some exports to an output directory.
+type daemonJob struct {
+	ID        string        `json:"id"`
+	State     string        `json:"state"`
+	Error     string        `json:"error,omitempty"`
+	Inputs    []exportInput `json:"inputs"`
+	OutputDir string        `json:"outputDir"`
+	Options   daemonOptions `json:"options"`
+	// Only limits the job to these videos, by name. It's set when the failed videos of a finished job are retried.
+	Only []string `json:"only,omitempty"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	Warnings int            `json:"warnings"` // Number of posts of the exports that couldn't be read.
+	Progress daemonProgress `json:"progress"`
+	Items    []daemonItem   `json:"items,omitempty"`
+
+	// While the job is running: its download job, and the index in Items of each item of its run.
+	engine   *downloadJob
+	runItems []int
+}
+
+// summary returns a copy of the job without its items, for listings.
+func (job *daemonJob) summary() daemonJob {
+	summary := *job
+	summary.Items = nil
+	summary.engine, summary.runItems = nil, nil
+	return summary
+}
+
+// apply updates the job's progress from a snapshot of its run. Items are matched by name, so that retrying some of
+// the videos of a job keeps the others' statuses.
+func (job *daemonJob) apply(snapshot progressSnapshot) {
+	job.Progress = newDaemonProgress(snapshot.progressCounters)
+	if snapshot.NewRun {
+		byName := map[string]int{}
+		for i, item := range job.Items {
+			byName[item.Name] = i
+		}
+		job.runItems = make([]int, len(snapshot.Items))
+		for _, item := range snapshot.Items {
+			i, ok := byName[item.Name]
+			if !ok {
+				i = len(job.Items)
+				job.Items = append(job.Items, daemonItem{})
+			}
+			job.runItems[item.Index] = i
+		}
+	}
+	for _, item := range snapshot.Items {
+		if item.Index < len(job.runItems) {
+			job.Items[job.runItems[item.Index]] = newDaemonItem(item)
+		}
+	}
+}
+
+func (job *daemonJob) done() bool {
+	return job.State == jobFinished || job.State == jobCancelled || job.State == jobFailed
+}
+
+// daemonState is what the daemon saves to disk.
+type daemonState struct {
+	NextID int          `json:"nextID"`
+	Jobs   []*daemonJob `json:"jobs"`
+}
+
+// daemon runs jobs in the background.
+type daemon struct {
+	path string
+	hub  *progressHub
+
+	// lock guards everything below, and the jobs.
+	lock    sync.Mutex
+	nextID  int
+	jobs    []*daemonJob
+	current *daemonJob // The job being run, if any.
+	saved   time.Time
+	wake    chan struct{}
+}
+
+// newDaemon creates a daemon with the jobs saved at path, if any. Jobs that were running when the daemon last stopped
+// are queued again.
+func newDaemon(path string) (*daemon, error) {
+	d := &daemon{path: path, hub: newProgressHub(), nextID: 1, wake: make(chan struct{}, 1)}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state daemonState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	d.nextID, d.jobs = state.NextID, state.Jobs
+	for _, job := range d.jobs {
+		if job.State == jobRunning {
+			// The videos it already downloaded are skipped when it runs again, if it skips existing videos.
+			logger.Warnf("Job %s was interrupted, queueing it again\n", job.ID)
+			job.State = jobQueued
+		}
+	}
+	return d, nil
+}
+
+// save writes the jobs to disk. The daemon must be locked.
+func (d *daemon) save() {
+	content, err := json.MarshalIndent(daemonState{NextID: d.nextID, Jobs: d.jobs}, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(d.path), 0777)
+	}
+	if err == nil {
+		tempPath := d.path + ".temp"
+		if err = os.WriteFile(tempPath, content, 0666); err == nil {
+			err = os.Rename(tempPath, d.path)
+		}
+	}
+	if err != nil {
+		logger.Errorf("Failed to save the daemon's jobs: %v\n", err)
+	}
+	d.saved = time.Now()
+}
+
+// notify wakes the run loop up, if it's waiting for a job.
+func (d *daemon) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *daemon) find(id string) *daemonJob {
+	for _, job := range d.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// run runs the queued jobs one after the other, forever.
+func (d *daemon) run() {
+	for {
+		d.lock.Lock()
+		var job *daemonJob
+		for _, queued := range d.jobs {
+			if queued.State == jobQueued {
+				job = queued
+				break
+			}
+		}
+		if job == nil {
+			d.lock.Unlock()
+			<-d.wake
+			continue
+		}
+		now := time.Now()
+		job.State, job.Error, job.StartedAt, job.FinishedAt = jobRunning, "", &now, nil
+		d.current = job
+		d.save()
+		d.lock.Unlock()
+
+		d.runJob(job)
+	}
+}
+
+// runJob runs a job until it's finished or cancelled.
+func (d *daemon) runJob(job *daemonJob) {
+	logger.Infof("Starting job %s\n", job.ID)
+	links, warnings, err := d.prepare(job)
+
+	d.lock.Lock()
+	job.Warnings = warnings
+	if err != nil || job.State == jobCancelled || len(links) == 0 {
+		if err != nil {
+			logger.Errorf("Job %s failed: %v\n", job.ID, err)
+			job.State, job.Error = jobFailed, redact(err.Error())
+		} else if job.State != jobCancelled {
+			logger.Infof("Job %s has nothing to download\n", job.ID)
+			job.State = jobFinished
+		}
+		d.finish(job)
+		d.lock.Unlock()
+		return
+	}
+	finished := make(chan struct{})
+	var once sync.Once
+	job.engine = launchJob(d.hub, job.OutputDir, links, job.Options.jobOptions(), func(*downloadJob) {
+		once.Do(func() { close(finished) })
+	})
+	if job.State == jobPaused {
+		// Paused while it was reading the exports.
+		job.engine.pause()
+	}
+	run := job.engine.run
+	d.lock.Unlock()
+
+	unsubscribe := run.subscribe(time.Second, func(snapshot progressSnapshot) {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		job.apply(snapshot)
+		if time.Since(d.saved) > 10*time.Second {
+			d.save()
+		}
+	})
+	<-finished
+	unsubscribe()
+
+	if job.Options.Gallery {
+		if err := buildGallery(job.OutputDir); err != nil {
+			logger.Errorf("Failed to update the gallery: %v\n", err)
+		}
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	items := run.itemSnapshots()
+	job.apply(progressSnapshot{progressCounters: run.counters(items), NewRun: true, Items: items})
+	if job.State != jobCancelled {
+		job.State = jobFinished
+	}
+	logger.Infof("Job %s %s\n", job.ID, job.State)
+	d.finish(job)
+}
+
+// finish clears the current job, and saves it. The daemon must be locked.
+func (d *daemon) finish(job *daemonJob) {
+	now := time.Now()
+	job.FinishedAt = &now
+	job.engine = nil
+	d.current = nil
+	d.save()
+}
+
+// prepare reads the exports of a job, and returns the videos it should download.
+func (d *daemon) prepare(job *daemonJob) ([]VideoLink, int, error) {
+	d.lock.Lock()
+	inputs, outputDir, options, only := job.Inputs, job.OutputDir, job.Options, job.Only
+	d.lock.Unlock()
+
+	links, exports, err := readExports(inputs)
+	if err != nil {
+		return nil, 0, err
+	}
+	warnings := 0
+	for _, export := range exports {
+		if export.Report == nil {
+			continue
+		}
+		for _, warning := range export.Report.Warnings {
+			logger.Warnf("%s: %v\n", export.Path, warning)
+		}
+		warnings += len(export.Report.Warnings)
+	}
+	links, result, err := syncExports(exports, links, outputDir, options.OnlyNew, options.FileNames)
+	if err != nil {
+		return nil, warnings, err
+	}
+	if len(result.Deleted) > 0 {
+		logger.Infof("%d posts from earlier exports aren't in these ones anymore\n", len(result.Deleted))
+	}
+	if len(only) > 0 {
+		var kept []VideoLink
+		for _, link := range links {
+			if contains(only, videoName(link, options.FileNames)) {
+				kept = append(kept, link)
+			}
+		}
+		links = kept
+	}
+	return links, warnings, nil
+}
+
+// submit queues a new job.
+func (d *daemon) submit(inputs []exportInput, outputDir string, options daemonOptions) *daemonJob {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	job := &daemonJob{
+		ID:        fmt.Sprint(d.nextID),
+		State:     jobQueued,
+		Inputs:    inputs,
+		OutputDir: outputDir,
+		Options:   options,
+		CreatedAt: time.Now(),
+	}
+	d.nextID++
+	d.jobs = append(d.jobs, job)
+	d.save()
+	d.notify()
+	logger.Infof("Queued job %s, downloading to %s\n", job.ID, outputDir)
+	return job
+}
+
+// pause stops a job from downloading any more videos until it's resumed. The daemon must be locked.
+func (d *daemon) pause(job *daemonJob) error {
+	switch job.State {
+	case jobQueued:
+	case jobRunning:
+		if job.engine != nil {
+			job.engine.pause()
+		}
+	default:
+		return fmt.Errorf("job %s is %s", job.ID, job.State)
+	}
+	job.State = jobPaused
+	return nil
+}
+
+// resume lets a paused job carry on. The daemon must be locked.
+func (d *daemon) resume(job *daemonJob) error {
+	if job.State != jobPaused {
+		return fmt.Errorf("job %s isn't paused", job.ID)
+	}
+	if job != d.current {
+		// It was paused before it started, or the daemon has restarted since.
+		job.State = jobQueued
+		d.notify()
+		return nil
+	}
+	job.State = jobRunning
+	if job.engine != nil {
+		job.engine.resume()
+	}
+	return nil
+}
+
+// cancel stops a job for good. The daemon must be locked.
+func (d *daemon) cancel(job *daemonJob) error {
+	if job.done() {
+		return fmt.Errorf("job %s is already %s", job.ID, job.State)
+	}
+	job.State = jobCancelled
+	if job.engine != nil {
+		// The run loop finishes the job once its downloads have wound down.
+		job.engine.cancelAll()
+	} else if job != d.current {
+		now := time.Now()
+		job.FinishedAt = &now
+	}
+	return nil
+}
+
+// retryFailed downloads the failed videos of a job again: right away if it's running, otherwise by queueing the job
+// again, limited to those videos. The daemon must be locked.
+func (d *daemon) retryFailed(job *daemonJob) (int, error) {
+	if job.engine != nil {
+		// Once the engine finished or was cancelled, runJob is about to record the job as done, after which it's
+		// retried like any other finished job.
+		retried, ok := job.engine.retryFailed()
+		if !ok {
+			return 0, fmt.Errorf("job %s is finishing, retry it once it's done", job.ID)
+		}
+		return retried, nil
+	}
+	if !job.done() {
+		return 0, fmt.Errorf("job %s is %s", job.ID, job.State)
+	}
+	var failed []string
+	for _, item := range job.Items {
+		if item.Status == statusFailed {
+			failed = append(failed, item.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return 0, nil
+	}
+	job.Only = failed
+	job.State, job.Error = jobQueued, ""
+	d.notify()
+	return len(failed), nil
+}
+
+// handler serves the daemon's API, requiring token if it isn't empty.
+func (d *daemon) handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/jobs", d.serveJobs)
+	mux.HandleFunc("/api/jobs/", d.serveJob)
+	mux.HandleFunc("/metrics", d.serveMetrics)
+	return requireToken(token, mux)
+}
+
+// serveMetrics serves the metrics of the downloads in the Prometheus text format.
+func (d *daemon) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	d.lock.Lock()
+	workers := 0
+	if d.current != nil && d.current.engine != nil {
+		workers = d.current.engine.activeWorkers()
+	}
+	d.lock.Unlock()
+	// The latest run stays the hub's once it's over, so that its final numbers can still be scraped.
+	run := d.hub.run.Load()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.write(w, run, workers)
+}
+
+// serveJobs lists the jobs on GET, and submits a new one on POST.
+func (d *daemon) serveJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		d.lock.Lock()
+		jobs := []daemonJob{}
+		for _, job := range d.jobs {
+			jobs = append(jobs, job.summary())
+		}
+		d.lock.Unlock()
+		writeJSONResponse(w, jobs)
+	case http.MethodPost:
+		request := struct {
+			Inputs    []exportInput `json:"inputs"`
+			OutputDir string        `json:"outputDir"`
+			Options   daemonOptions `json:"options"`
+		}{Options: defaultDaemonOptions()}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid job: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := validateJobRequest(request.Inputs, request.OutputDir, request.Options); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		job := d.submit(request.Inputs, request.OutputDir, request.Options)
+		d.lock.Lock()
+		summary := job.summary()
+		d.lock.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		writeJSONResponse(w, summary)
+	default:
+		http.Error(w, "Only GET and POST are allowed.", http.StatusMethodNotAllowed)
+	}
+}
+
+// validateJobRequest checks a submitted job, guessing the types of its inputs if they're missing.
+func validateJobRequest(inputs []exportInput, outputDir string, options daemonOptions) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("At least one input is required.")
+	}
+	for i := range inputs {
+		if inputs[i].Path == "" {
+			return fmt.Errorf("Every input needs a path.")
+		}
+		if inputs[i].Type == "" {
+			inputs[i].Type = detectFileType(inputs[i].Path)
+		}
+		if inputs[i].Type != "Posts.txt" && inputs[i].Type != "user_data.json" {
+			return fmt.Errorf("The type of %s must be \"Posts.txt\" or \"user_data.json\".", inputs[i].Path)
+		}
+	}
+	if outputDir == "" {
+		return fmt.Errorf("outputDir is required.")
+	}
+	if info, err := os.Stat(outputDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a folder.", outputDir)
+	}
+	return options.validate()
+}
+
+// serveJob serves /api/jobs/{id} and its actions: events, pause, resume, cancel and retry-failed.
+func (d *daemon) serveJob(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	d.lock.Lock()
+	job := d.find(id)
+	d.lock.Unlock()
+	if job == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if action == "" || action == "events" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET is allowed.", http.StatusMethodNotAllowed)
+			return
+		}
+		if action == "events" {
+			d.serveEvents(w, r, job)
+			return
+		}
+		d.lock.Lock()
+		content, err := json.Marshal(job)
+		d.lock.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSONResponse(w, json.RawMessage(content))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var err error
+	switch action {
+	case "pause":
+		err = d.pause(job)
+	case "resume":
+		err = d.resume(job)
+	case "cancel":
+		err = d.cancel(job)
+	case "retry-failed":
+		var retried int
+		retried, err = d.retryFailed(job)
+		if err == nil {
+			logger.Infof("Retrying %d failed videos of job %s\n", retried, job.ID)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	d.save()
+	writeJSONResponse(w, job.summary())
+}
+
+// serveEvents streams the progress of a job as server-sent events: a "job" event with the job (without its items)
+// whenever its state changes, and "progress" events with its progress and the items that changed, while it runs.
+// The stream ends once the job is over.
+func (d *daemon) serveEvents(w http.ResponseWriter, r *http.Request, job *daemonJob) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming isn't supported.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	send := func(event string, value interface{}) bool {
+		content, err := json.Marshal(value)
+		if err != nil {
+			logger.Errorf("Failed to write event: %v\n", err)
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, content); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	snapshots := make(chan progressSnapshot)
+	stop := make(chan struct{})
+	defer close(stop)
+	var run *runProgress
+	var unsubscribe func()
+	defer func() {
+		if unsubscribe != nil {
+			unsubscribe()
+		}
+	}()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastState := ""
+	for {
+		d.lock.Lock()
+		summary := job.summary()
+		engine := job.engine
+		d.lock.Unlock()
+		if engine != nil && engine.run != run {
+			if unsubscribe != nil {
+				unsubscribe()
+			}
+			run = engine.run
+			unsubscribe = run.subscribe(500*time.Millisecond, func(snapshot progressSnapshot) {
+				select {
+				case snapshots <- snapshot:
+				case <-stop:
+				}
+			})
+		}
+		if summary.State != lastState {
+			if !send("job", summary) {
+				return
+			}
+			lastState = summary.State
+		}
+		if summary.done() {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case snapshot := <-snapshots:
+			items := make([]daemonItem, len(snapshot.Items))
+			for i, item := range snapshot.Items {
+				items[i] = newDaemonItem(item)
+			}
+			if !send("progress", map[string]interface{}{"progress": newDaemonProgress(snapshot.progressCounters), "items": items}) {
+				return
+			}
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ncruces/zenity"
+)
+
+// Number of most recent logs included in a diagnostics bundle.
+const diagnosticsLogs = 5
+
+// pathRedactor removes what could identify the user from text: the query strings of URLs (see redact), the home
+// folder, and the user's name where it's a folder in a path.
+type pathRedactor struct {
+	homes []string // The home folder, as it may appear in text and JSON.
+	names []*regexp.Regexp
+}
+
+func newPathRedactor() *pathRedactor {
+	r := &pathRedactor{}
+	home, _ := os.UserHomeDir()
+	if home != "" {
+		r.homes = []string{home, filepath.ToSlash(home), strings.ReplaceAll(home, `\`, `\\`)}
+	}
+	names := map[string]bool{}
+	if current, err := user.Current(); err == nil {
+		// On Windows, the user name includes the domain, e.g. DESKTOP-1234\name.
+		names[current.Username[strings.LastIndex(current.Username, `\`)+1:]] = true
+	}
+	if home != "" {
+		names[filepath.Base(home)] = true
+	}
+	for name := range names {
+		if len(name) > 1 {
+			r.names = append(r.names, regexp.MustCompile(`(?im)([/\\])`+regexp.QuoteMeta(name)+`([/\\"'\s]|$)`))
+		}
+	}
+	return r
+}
+
+func (r *pathRedactor) redact(s string) string {
+	s = redact(s)
+	for _, home := range r.homes {
+		s = strings.ReplaceAll(s, home, "~")
+	}
+	for _, name := range r.names {
+		s = name.ReplaceAllString(s, "$1<user>$2")
+	}
+	return s
+}
+
+// diagnosticsBundle writes the files of a diagnostics bundle to a zip, redacting all of them.
+type diagnosticsBundle struct {
+	zip      *zip.Writer
+	redactor *pathRedactor
+}
+
+func (b *diagnosticsBundle) add(name, content string) error {
+	w, err := b.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte(b.redactor.redact(content)))
+	return err
+}
+
+func (b *diagnosticsBundle) addJSON(name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.add(name, string(data))
+}
+
+// appVersion describes the version of the app, and the commit it was built from when known.
+func appVersion() string {
+	version := "unknown"
+	if app := fyne.CurrentApp(); app != nil {
+		metadata := app.Metadata()
+		if metadata.Version != "" {
+			version = fmt.Sprintf("%s (build %d)", metadata.Version, metadata.Build)
+		}
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				version += ", commit " + setting.Value
+			}
+		}
+	}
+	return version
+}
+
+// diagnosticsEnvironment describes the app and the computer it runs on.
+func diagnosticsEnvironment(outputDir string) map[string]interface{} {
+	freeSpace := map[string]string{}
+	dirs := map[string]string{"output folder": outputDir}
+	if dataDir, err := appDataDir(); err == nil {
+		dirs["app data folder"] = dataDir
+	}
+	for label, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if free, err := freeDiskSpace(dir); err != nil {
+			freeSpace[label] = fmt.Sprintf("unknown: %v", err)
+		} else {
+			freeSpace[label] = humanize.Bytes(free)
+		}
+	}
+	return map[string]interface{}{
+		"version":       appVersion(),
+		"goVersion":     runtime.Version(),
+		"os":            runtime.GOOS,
+		"arch":          runtime.GOARCH,
+		"cpus":          runtime.NumCPU(),
+		"freeDiskSpace": freeSpace,
+		"createdAt":     time.Now().Format(time.RFC3339),
+	}
+}
+
+// diagnosticsErrors summarizes the latest run: its videos by status, and those that failed with their error.
+func diagnosticsErrors(items []itemSnapshot) map[string]interface{} {
+	type failure struct {
+		Name     string `json:"name"`
+		Link     string `json:"link"`
+		Attempts int    `json:"attempts"`
+		Error    string `json:"error"`
+	}
+	counts := map[string]int{}
+	failures := []failure{}
+	for _, item := range items {
+		counts[item.Status]++
+		if item.Status == statusFailed {
+			failures = append(failures, failure{item.Name, item.Link, item.Attempts, item.Error})
+		}
+	}
+	return map[string]interface{}{"statuses": counts, "failed": failures}
+}
+
+// recentLogs returns the paths of the most recent logs, newest first.
+func recentLogs(limit int) ([]string, error) {
+	dataDir, err := appDataDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dataDir, "log", "log-*"))
+	if err != nil {
+		return nil, err
+	}
+	// Log names start with the time they were created at, so they sort by date.
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	if len(paths) > limit {
+		paths = paths[:limit]
+	}
+	return paths, nil
+}
+
+// writeDiagnostics writes a zip with what's needed to look into a problem: the environment, the settings, a summary
+// of the latest run's errors, the manifest of the output folder and the most recent logs. Signed URLs and personal
+// paths are redacted from all of them.
+func writeDiagnostics(path string, settings map[string]interface{}, outputDir string, items []itemSnapshot) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	bundle := &diagnosticsBundle{zip: zip.NewWriter(file), redactor: newPathRedactor()}
+
+	if err := bundle.addJSON("environment.json", diagnosticsEnvironment(outputDir)); err != nil {
+		return err
+	}
+	if err := bundle.addJSON("settings.json", settings); err != nil {
+		return err
+	}
+	if err := bundle.addJSON("errors.json", diagnosticsErrors(items)); err != nil {
+		return err
+	}
+	if outputDir != "" {
+		if manifest, err := loadManifest(outputDir); err != nil {
+			logger.Warnf("Not including the manifest in the diagnostics: %v\n", err)
+		} else if err := bundle.addJSON("manifest.json", manifest); err != nil {
+			return err
+		}
+	}
+	logs, err := recentLogs(diagnosticsLogs)
+	if err != nil {
+		logger.Warnf("Not including the logs in the diagnostics: %v\n", err)
+	}
+	for _, log := range logs {
+		content, err := os.ReadFile(log)
+		if err != nil {
+			return err
+		}
+		if err := bundle.add("logs/"+filepath.Base(log), string(content)); err != nil {
+			return err
+		}
+	}
+
+	if err := bundle.zip.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// diagnosticsSettings returns the settings of the window, as they'd be used by the next download.
+func (appState *appState) diagnosticsSettings() map[string]interface{} {
+	inputFile, _ := appState.inputFile.Get()
+	fileType, _ := appState.fileType.Get()
+	outputDir, _ := appState.outputDir.Get()
+	fileNames, _ := appState.fileNames.Get()
+	var moreInputs []string
+	for _, input := range appState.extraInputs() {
+		moreInputs = append(moreInputs, input.Path)
+	}
+	onlyNew, _ := appState.onlyNew.Get()
+	gallery, _ := appState.gallery.Get()
+	options := appState.jobOptions()
+	return map[string]interface{}{
+		"inputFile":     inputFile,
+		"fileType":      fileType,
+		"moreInputs":    moreInputs,
+		"outputDir":     outputDir,
+		"skipExisting":  options.skipExisting,
+		"skipMode":      options.skipMode,
+		"onlyNew":       onlyNew,
+		"fileNames":     fileNames,
+		"writeSidecars": options.writeSidecars,
+		"gallery":       gallery,
+		"parallelism":   options.parallelism,
+		"order":         options.order,
+		"logLevel":      minLogLevel.String(),
+		"logFormat":     logFormat,
+	}
+}
+
+func createDiagnostics(appState *appState) {
+	path, err := zenity.SelectFileSave(
+		zenity.Title("Create diagnostics bundle"),
+		zenity.Filename(fmt.Sprintf("tiktok-archiver-diagnostics-%s.zip", time.Now().Format("2006-01-02"))),
+		zenity.ConfirmOverwrite(),
+		zenity.FileFilters{
+			{Name: "Zip files", Patterns: []string{"*.zip"}, CaseFold: false},
+		},
+	)
+	if err != nil {
+		if err != zenity.ErrCanceled {
+			logger.Errorf("Error selecting file: %v", err)
+		}
+		return
+	}
+	settings := appState.diagnosticsSettings()
+	outputDir, _ := appState.outputDir.Get()
+	var items []itemSnapshot
+	if run := appState.progress.run.Load(); run != nil {
+		items = run.itemSnapshots()
+	}
+	go func() {
+		if err := writeDiagnostics(path, settings, outputDir, items); err != nil {
+			logger.Errorf("Failed to create diagnostics bundle: %v\n", err)
+			dialog.ShowError(err, appState.window)
+			return
+		}
+		logger.Infof("Created diagnostics bundle %s\n", path)
+		dialog.ShowInformation("Create diagnostics bundle",
+			fmt.Sprintf("Saved %s. Links and personal folders were left out, so it can be attached to a bug report.", filepath.Base(path)),
+			appState.window)
+	}()
+}
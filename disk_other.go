@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, fmt.Errorf("not supported on %s", runtime.GOOS)
+}
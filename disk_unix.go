@@ -0,0 +1,14 @@
+//go:build darwin || dragonfly || freebsd || linux
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// freeDiskSpace returns the number of bytes available to the user on the disk holding path.
+func freeDiskSpace(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// freeDiskSpace returns the number of bytes available to the user on the disk holding path.
+func freeDiskSpace(path string) (uint64, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &available, &total, &free); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
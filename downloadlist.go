@@ -0,0 +1,454 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// downloadState is the data model behind the download list. It holds plain snapshots of every download rather than
+// per-row data bindings: rows are recycled by the list as it scrolls, so instead of binding a row to an item (and
+// having to unbind it again), each row is simply redrawn from the model whenever it's reused or the model changes.
+type downloadState struct {
+	lock     sync.RWMutex
+	items    []itemSnapshot
+	counts   statusCounts
+	selected int // Index of the selected item, or -1.
+
+	// The list shows a filtered and sorted view of the items. `view` maps list rows to item indexes.
+	view       []int
+	listSelect int // Row selected in the list widget, or -1.
+	filter     map[string]bool
+	query      string
+	sortOrder  string
+
+	widget      *widget.List
+	detailsPane *downloadDetailsPane
+	toolbar     *downloadToolbar
+}
+
+// Sort orders of the download list.
+const (
+	sortNewestFirst = "Newest first"
+	sortOldestFirst = "Oldest first"
+	sortName        = "Name"
+	sortStatus      = "Status"
+	sortSize        = "Largest first"
+)
+
+var sortOrders = []string{sortNewestFirst, sortOldestFirst, sortName, sortStatus, sortSize}
+
+func newDownloadState() *downloadState {
+	return &downloadState{
+		selected:   -1,
+		listSelect: -1,
+		filter:     map[string]bool{},
+		sortOrder:  sortNewestFirst,
+	}
+}
+
+func (d *downloadState) length() int {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return len(d.view)
+}
+
+// get returns the item shown in the given row of the list.
+func (d *downloadState) get(row int) (itemSnapshot, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if row < 0 || row >= len(d.view) {
+		return itemSnapshot{}, false
+	}
+	return d.items[d.view[row]], true
+}
+
+// visibleItems returns the items shown in the list, in the order they're shown in.
+func (d *downloadState) visibleItems() []itemSnapshot {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	items := make([]itemSnapshot, len(d.view))
+	for row, i := range d.view {
+		items[row] = d.items[i]
+	}
+	return items
+}
+
+// runItems returns every item of the run shown in the list, including those its filters hide.
+func (d *downloadState) runItems() []itemSnapshot {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return append([]itemSnapshot(nil), d.items...)
+}
+
+// arrange returns the items that the list's filters let through, sorted the way the list is.
+func (d *downloadState) arrange(items []itemSnapshot) []itemSnapshot {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	var arranged []itemSnapshot
+	for _, item := range items {
+		if d.matches(item) {
+			arranged = append(arranged, item)
+		}
+	}
+	sort.SliceStable(arranged, func(i, j int) bool {
+		return d.less(arranged[i], arranged[j])
+	})
+	return arranged
+}
+
+// apply updates the model from a progress snapshot and redraws the visible rows.
+func (d *downloadState) apply(snapshot progressSnapshot) {
+	d.lock.Lock()
+	if snapshot.NewRun {
+		d.items = make([]itemSnapshot, len(snapshot.Items))
+		d.selected = -1
+	}
+	for _, item := range snapshot.Items {
+		d.items[item.Index] = item
+	}
+	d.counts = snapshot.StatusCounts
+	d.lock.Unlock()
+
+	d.refresh()
+}
+
+// setFilter shows only items in the given statuses (or all of them, if empty) whose name or date contains query.
+func (d *downloadState) setFilter(statuses map[string]bool, query string) {
+	d.lock.Lock()
+	d.filter = statuses
+	d.query = strings.ToLower(strings.TrimSpace(query))
+	d.lock.Unlock()
+	d.refresh()
+}
+
+func (d *downloadState) setSortOrder(order string) {
+	d.lock.Lock()
+	d.sortOrder = order
+	d.lock.Unlock()
+	d.refresh()
+}
+
+// refresh rebuilds the view and redraws everything showing the model.
+func (d *downloadState) refresh() {
+	d.lock.Lock()
+	d.view = d.view[:0]
+	for i, item := range d.items {
+		if d.matches(item) {
+			d.view = append(d.view, i)
+		}
+	}
+	sort.SliceStable(d.view, func(i, j int) bool {
+		return d.less(d.items[d.view[i]], d.items[d.view[j]])
+	})
+	// Keep the selection on the same item as the view changes around it.
+	selectedRow := -1
+	for row, i := range d.view {
+		if i == d.selected {
+			selectedRow = row
+		}
+	}
+	previousRow := d.listSelect
+	counts := d.counts
+	d.lock.Unlock()
+
+	if d.widget == nil {
+		return
+	}
+	switch {
+	case selectedRow == -1 && previousRow != -1:
+		d.widget.UnselectAll()
+	case selectedRow != previousRow:
+		d.widget.Select(selectedRow)
+	}
+	d.widget.Refresh()
+	d.detailsPane.refresh()
+	d.toolbar.setCounts(counts)
+}
+
+func (d *downloadState) matches(item itemSnapshot) bool {
+	if len(d.filter) > 0 && !d.filter[item.Status] {
+		return false
+	}
+	if d.query == "" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(item.Name), d.query) || strings.Contains(strings.ToLower(item.Date), d.query)
+}
+
+func (d *downloadState) less(a, b itemSnapshot) bool {
+	switch d.sortOrder {
+	case sortOldestFirst:
+		return a.Date < b.Date
+	case sortName:
+		return a.Name < b.Name
+	case sortStatus:
+		return statusRank(a.Status) < statusRank(b.Status)
+	case sortSize:
+		return a.ContentLength > b.ContentLength
+	default:
+		return a.Date > b.Date
+	}
+}
+
+func statusRank(status string) int {
+	for i, s := range allStatuses {
+		if s == status {
+			return i
+		}
+	}
+	return len(allStatuses)
+}
+
+// selectRow is called when a row of the list is selected, or with -1 when the selection is cleared.
+func (d *downloadState) selectRow(row int) {
+	d.lock.Lock()
+	d.listSelect = row
+	d.selected = -1
+	if row >= 0 && row < len(d.view) {
+		d.selected = d.view[row]
+	}
+	d.lock.Unlock()
+	d.detailsPane.refresh()
+}
+
+func (d *downloadState) selectedItem() (itemSnapshot, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.selected < 0 || d.selected >= len(d.items) {
+		return itemSnapshot{}, false
+	}
+	return d.items[d.selected], true
+}
+
+// downloadToolbar sits above the download list and lets the user filter it by status, search it and sort it.
+type downloadToolbar struct {
+	container    *fyne.Container
+	chips        []*widget.Button
+	searchEntry  *widget.Entry
+	statuses     map[string]bool
+	downloads    *downloadState
+	latestCounts statusCounts
+}
+
+func newDownloadToolbar(appState *appState) *downloadToolbar {
+	toolbar := &downloadToolbar{
+		searchEntry: widget.NewEntry(),
+		statuses:    map[string]bool{},
+		downloads:   appState.downloads,
+	}
+	chips := container.NewGridWithColumns(3)
+	for _, status := range allStatuses {
+		status := status
+		chip := widget.NewButton(fmt.Sprintf("%s (0)", status), nil)
+		chip.OnTapped = func() {
+			toolbar.statuses[status] = !toolbar.statuses[status]
+			if toolbar.statuses[status] {
+				chip.Importance = widget.HighImportance
+			} else {
+				delete(toolbar.statuses, status)
+				chip.Importance = widget.MediumImportance
+			}
+			chip.Refresh()
+			toolbar.applyFilter()
+		}
+		toolbar.chips = append(toolbar.chips, chip)
+		chips.Add(chip)
+	}
+	toolbar.searchEntry.SetPlaceHolder("Search by name or date")
+	toolbar.searchEntry.OnChanged = func(string) {
+		toolbar.applyFilter()
+	}
+	sortSelect := widget.NewSelect(sortOrders, func(order string) {
+		appState.downloads.setSortOrder(order)
+	})
+	sortSelect.SetSelected(sortNewestFirst)
+	toolbar.container = container.NewVBox(
+		chips,
+		container.NewBorder(nil, nil, nil, sortSelect, toolbar.searchEntry),
+	)
+	return toolbar
+}
+
+func (toolbar *downloadToolbar) applyFilter() {
+	statuses := make(map[string]bool, len(toolbar.statuses))
+	for status := range toolbar.statuses {
+		statuses[status] = true
+	}
+	toolbar.downloads.setFilter(statuses, toolbar.searchEntry.Text)
+}
+
+// setCounts shows the number of items of each status on its chip.
+func (toolbar *downloadToolbar) setCounts(counts statusCounts) {
+	if toolbar == nil || counts == toolbar.latestCounts {
+		return
+	}
+	toolbar.latestCounts = counts
+	for i, chip := range toolbar.chips {
+		chip.SetText(fmt.Sprintf("%s (%d)", allStatuses[i], counts[i]))
+	}
+}
+
+// downloadRow is a single row of the download list. Its actions are available from a menu button, or by
+// right-clicking the row.
+type downloadRow struct {
+	widget.BaseWidget
+	statusIcon    *widget.Icon
+	fileNameLabel *widget.Label
+	progressBar   *widget.ProgressBar
+	detailsLabel  *widget.Label
+	menuButton    *widget.Button
+
+	appState *appState
+	item     itemSnapshot
+}
+
+func newDownloadRow(appState *appState) *downloadRow {
+	row := &downloadRow{
+		statusIcon:    widget.NewIcon(getStatusIcon(statusQueued)),
+		fileNameLabel: widget.NewLabel(""),
+		progressBar:   widget.NewProgressBar(),
+		detailsLabel:  widget.NewLabel(""),
+		appState:      appState,
+	}
+	row.detailsLabel.Wrapping = fyne.TextTruncate
+	row.menuButton = widget.NewButtonWithIcon("", theme.MoreVerticalIcon(), func() {
+		pos := fyne.CurrentApp().Driver().AbsolutePositionForObject(row.menuButton)
+		showItemMenu(appState, row.item, pos.Add(fyne.NewPos(0, row.menuButton.Size().Height)))
+	})
+	row.menuButton.Importance = widget.LowImportance
+	row.ExtendBaseWidget(row)
+	return row
+}
+
+func (row *downloadRow) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewBorder(nil, nil, nil, row.menuButton,
+		container.New(layout.NewFormLayout(),
+			row.statusIcon,
+			container.NewVBox(
+				container.New(layout.NewFormLayout(),
+					row.fileNameLabel, row.progressBar,
+				),
+				row.detailsLabel,
+			),
+		),
+	))
+}
+
+func (row *downloadRow) TappedSecondary(e *fyne.PointEvent) {
+	showItemMenu(row.appState, row.item, e.AbsolutePosition)
+}
+
+// setItem redraws the row to show the given item. Widgets are only touched when their value actually changes, since
+// all visible rows are redrawn on every progress update.
+func (row *downloadRow) setItem(item itemSnapshot) {
+	row.item = item
+	if icon := getStatusIcon(item.Status); row.statusIcon.Resource != icon {
+		row.statusIcon.SetResource(icon)
+	}
+	if row.fileNameLabel.Text != item.Name {
+		row.fileNameLabel.SetText(item.Name)
+	}
+	if fraction := item.fraction(); row.progressBar.Value != fraction {
+		row.progressBar.SetValue(fraction)
+	}
+	if details := item.details(); row.detailsLabel.Text != details {
+		row.detailsLabel.SetText(details)
+	}
+}
+
+func newDownloadListWidget(appState *appState) *widget.List {
+	list := widget.NewList(
+		func() int {
+			return appState.downloads.length()
+		},
+		func() fyne.CanvasObject {
+			return newDownloadRow(appState)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			item, ok := appState.downloads.get(id)
+			if !ok {
+				return
+			}
+			obj.(*downloadRow).setItem(item)
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		appState.downloads.selectRow(id)
+	}
+	list.OnUnselected = func(id widget.ListItemID) {
+		appState.downloads.selectRow(-1)
+	}
+	return list
+}
+
+// downloadDetailsPane shows everything known about the download selected in the list, including the full error of
+// a failed download so that it can be copied into a bug report.
+type downloadDetailsPane struct {
+	container    *fyne.Container
+	nameLabel    *widget.Label
+	statusLabel  *widget.Label
+	detailsLabel *widget.Label
+	errorLabel   *widget.Label
+	downloads    *downloadState
+}
+
+func newDownloadDetailsPane(appState *appState) *downloadDetailsPane {
+	pane := &downloadDetailsPane{
+		nameLabel:    widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		statusLabel:  widget.NewLabel(""),
+		detailsLabel: widget.NewLabel(""),
+		errorLabel:   widget.NewLabel(""),
+		downloads:    appState.downloads,
+	}
+	pane.detailsLabel.Wrapping = fyne.TextWrapWord
+	pane.errorLabel.Wrapping = fyne.TextWrapWord
+	copyButton := widget.NewButtonWithIcon("Copy error", theme.ContentCopyIcon(), func() {
+		item, ok := pane.downloads.selectedItem()
+		if !ok {
+			return
+		}
+		appState.window.Clipboard().SetContent(errorReport(item))
+	})
+	logButton := widget.NewButtonWithIcon("Show log", theme.DocumentIcon(), func() {
+		item, ok := pane.downloads.selectedItem()
+		if !ok {
+			return
+		}
+		showLogViewer(appState, item.Name)
+	})
+	closeButton := widget.NewButtonWithIcon("", theme.CancelIcon(), func() {
+		appState.downloads.widget.UnselectAll()
+	})
+	pane.container = container.NewVBox(
+		widget.NewSeparator(),
+		container.NewBorder(nil, nil, nil, closeButton, pane.nameLabel),
+		pane.statusLabel,
+		pane.detailsLabel,
+		pane.errorLabel,
+		container.NewHBox(layout.NewSpacer(), logButton, copyButton),
+	)
+	pane.container.Hide()
+	return pane
+}
+
+// refresh redraws the pane from the currently selected item, hiding it if there is none.
+func (pane *downloadDetailsPane) refresh() {
+	item, ok := pane.downloads.selectedItem()
+	if !ok {
+		pane.container.Hide()
+		return
+	}
+	pane.nameLabel.SetText(item.Name)
+	pane.statusLabel.SetText(fmt.Sprintf("Status: %s, attempts: %d", item.Status, item.Attempts))
+	pane.detailsLabel.SetText(item.details())
+	pane.errorLabel.SetText(item.Error)
+	pane.container.Show()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+)
+
+// newTestDownloadList sets up a download list the way the window does, in a test window.
+func newTestDownloadList(t *testing.T) (*appState, fyne.Window) {
+	app := test.NewApp()
+	t.Cleanup(app.Quit)
+	window := app.NewWindow("downloads")
+	appState := &appState{window: window, downloads: newDownloadState()}
+	appState.downloads.widget = newDownloadListWidget(appState)
+	appState.downloads.detailsPane = newDownloadDetailsPane(appState)
+	appState.downloads.toolbar = newDownloadToolbar(appState)
+	window.SetContent(appState.downloads.widget)
+	window.Resize(fyne.NewSize(600, 800))
+	return appState, window
+}
+
+// testRunSnapshot describes a new run of n items, in various statuses.
+func testRunSnapshot(n int) progressSnapshot {
+	snapshot := progressSnapshot{NewRun: true, Items: make([]itemSnapshot, n)}
+	for i := range snapshot.Items {
+		status := allStatuses[i%len(allStatuses)]
+		snapshot.Items[i] = itemSnapshot{
+			Index:         i,
+			Name:          fmt.Sprintf("video-%05d.mp4", i),
+			Date:          fmt.Sprintf("2022-01-01 00:%02d:%02d", i/60%60, i%60),
+			Status:        status,
+			ContentLength: int64(i+1) * 1000,
+			BytesDone:     int64(i+1) * 500,
+		}
+		snapshot.StatusCounts[i%len(allStatuses)]++
+	}
+	return snapshot
+}
+
+// visibleRows returns the rows the list currently shows. Unlike test.LaidOutObjects, it doesn't lay anything out, which
+// would make the list recreate its rows.
+func visibleRows(object fyne.CanvasObject) []*downloadRow {
+	if !object.Visible() {
+		return nil
+	}
+	var children []fyne.CanvasObject
+	switch object := object.(type) {
+	case *downloadRow:
+		return []*downloadRow{object}
+	case fyne.Widget:
+		children = test.WidgetRenderer(object).Objects()
+	case *fyne.Container:
+		children = object.Objects
+	}
+	var rows []*downloadRow
+	for _, child := range children {
+		rows = append(rows, visibleRows(child)...)
+	}
+	return rows
+}
+
+func TestDownloadListScrolling(t *testing.T) {
+	const items = 5000
+	appState, window := newTestDownloadList(t)
+	downloads := appState.downloads
+	list := downloads.widget
+	// Only a screenful of rows is ever shown, however long the list is: as many as fit in its height, plus one cut at
+	// each end.
+	maxRows := int(list.Size().Height/newDownloadRow(appState).MinSize().Height) + 2
+
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	downloads.apply(testRunSnapshot(items))
+	if got := downloads.length(); got != items {
+		t.Fatalf("the list has %d rows, want %d", got, items)
+	}
+	checkRows := func() []*downloadRow {
+		rows := visibleRows(window.Content())
+		if len(rows) == 0 || len(rows) > maxRows {
+			t.Fatalf("%d rows are shown, want between 1 and %d", len(rows), maxRows)
+		}
+		for _, row := range rows {
+			// A recycled row must show its current item, not whatever it showed before.
+			downloads.lock.RLock()
+			want := downloads.items[row.item.Index]
+			downloads.lock.RUnlock()
+			if row.item != want {
+				t.Fatalf("a row shows %+v, want %+v", row.item, want)
+			}
+			if row.fileNameLabel.Text != want.Name || row.progressBar.Value != want.fraction() ||
+				row.statusIcon.Resource != getStatusIcon(want.Status) {
+				t.Fatalf("the row of %s isn't drawn from its item", want.Name)
+			}
+		}
+		return rows
+	}
+
+	// Scroll through the whole list like a user would, finishing the downloads on screen every now and then like
+	// progress updates do.
+	center := fyne.NewPos(300, 400)
+	for step := 0; ; step++ {
+		rows := checkRows()
+		if step%10 == 0 {
+			update := progressSnapshot{}
+			for _, row := range rows {
+				item := row.item
+				item.Status = statusSucceeded
+				item.BytesDone = item.ContentLength
+				update.Items = append(update.Items, item)
+			}
+			downloads.apply(update)
+			checkRows()
+		}
+		last, _ := downloads.get(items - 1)
+		if rows[len(rows)-1].item.Index == last.Index {
+			break
+		}
+		test.Scroll(window.Canvas(), center, 0, -300)
+	}
+	// Jumping anywhere in the list shows the right items too.
+	for row := items - 1; row >= 0; row -= 97 {
+		list.ScrollTo(row)
+		want, _ := downloads.get(row)
+		shown := false
+		for _, r := range checkRows() {
+			shown = shown || r.item.Index == want.Index
+		}
+		if !shown {
+			t.Errorf("%s isn't shown after scrolling to row %d", want.Name, row)
+		}
+	}
+
+	var after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if grown := int64(after.HeapAlloc) - int64(before.HeapAlloc); grown > 16<<20 {
+		t.Errorf("the heap grew by %d MB while scrolling, want at most 16 MB", grown>>20)
+	}
+}
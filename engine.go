@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Orders in which the items of a job are downloaded.
+const (
+	orderNewestFirst   = "Newest first"
+	orderOldestFirst   = "Oldest first"
+	orderSmallestFirst = "Smallest first"
+)
+
+var downloadOrders = []string{orderNewestFirst, orderOldestFirst, orderSmallestFirst}
+
+// Ways of telling whether a video was already downloaded, when skipping existing videos.
+const (
+	skipIfExists      = "The file exists"
+	skipIfSizeMatches = "Its size matches TikTok's"
+	skipIfHashMatches = "Its checksum matches the manifest"
+)
+
+var skipModes = []string{skipIfExists, skipIfSizeMatches, skipIfHashMatches}
+
+type jobOptions struct {
+	skipExisting  bool
+	skipMode      string
+	parallelism   int
+	order         string
+	writeSidecars bool   // Whether to save the details of each post next to its video.
+	fileNames     string // The template videos are named with, see videoName.
+}
+
+// downloadJob downloads the items of a run with a pool of workers. Workers are started on demand as items are
+// queued and exit once the queue is empty, so that single items can be queued again (e.g. retried) after the rest of
+// the job finished.
+type downloadJob struct {
+	run      *runProgress
+	links    []VideoLink
+	options  jobOptions
+	manifest *manifest
+	// onFinish is called every time the last worker exits.
+	onFinish func()
+
+	lock     sync.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	queue    []int
+	workers  int
+	paused   bool
+	finished bool // Set once the last worker exits with nothing left to do, until items are queued again.
+}
+
+func newDownloadJob(run *runProgress, links []VideoLink, options jobOptions, manifest *manifest, onFinish func()) *downloadJob {
+	if options.parallelism < 1 {
+		options.parallelism = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &downloadJob{
+		run:      run,
+		links:    links,
+		options:  options,
+		manifest: manifest,
+		onFinish: onFinish,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// launchJob starts downloading links to outputDir, as a new run of hub. onFinish is called every time the job's last
+// worker exits.
+func launchJob(hub *progressHub, outputDir string, links []VideoLink, options jobOptions, onFinish func(job *downloadJob)) *downloadJob {
+	names := make([]string, len(links))
+	paths := make([]string, len(links))
+	for i, link := range links {
+		names[i] = videoName(link, options.fileNames)
+		paths[i] = filepath.Join(outputDir, filepath.FromSlash(names[i]))
+		if link.Account != "" {
+			if err := os.MkdirAll(filepath.Dir(paths[i]), 0777); err != nil {
+				logger.Errorf("Failed to create folder for %s: %v\n", link.Account, err)
+			}
+		}
+	}
+
+	manifest, err := loadManifest(outputDir)
+	if err != nil {
+		if path, setAsideErr := manifest.setAside(); setAsideErr != nil {
+			logger.Errorf("Failed to load manifest, and to move it out of the way, so this run won't update it: %v, %v\n", err, setAsideErr)
+		} else {
+			logger.Errorf("Failed to load manifest, starting a new one. The old one was moved to %s: %v\n", path, err)
+		}
+	}
+
+	run := hub.startRun(names, paths, links)
+	var job *downloadJob
+	job = newDownloadJob(run, links, options, manifest, func() {
+		onFinish(job)
+	})
+	go job.start()
+	return job
+}
+
+// start queues every item of the job, in the order given by the job's options.
+func (job *downloadJob) start() {
+	indexes := make([]int, len(job.run.items))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	switch job.options.order {
+	case orderOldestFirst:
+		sort.SliceStable(indexes, func(a, b int) bool {
+			return job.links[indexes[a]].Date < job.links[indexes[b]].Date
+		})
+	case orderSmallestFirst:
+		job.fetchSizes(indexes)
+		sort.SliceStable(indexes, func(a, b int) bool {
+			sizeA := job.run.items[indexes[a]].contentLength.Load()
+			sizeB := job.run.items[indexes[b]].contentLength.Load()
+			// Unknown sizes go last.
+			return sizeA > 0 && (sizeB <= 0 || sizeA < sizeB)
+		})
+	default:
+		sort.SliceStable(indexes, func(a, b int) bool {
+			return job.links[indexes[a]].Date > job.links[indexes[b]].Date
+		})
+	}
+	job.enqueue(indexes...)
+}
+
+// fetchSizes looks up the size of each item with HEAD requests, so that the items can be ordered by size before any
+// of them is downloaded.
+func (job *downloadJob) fetchSizes(indexes []int) {
+	logger.Debugf("Checking the sizes of %d videos...\n", len(indexes))
+	job.lock.Lock()
+	ctx := job.ctx
+	job.lock.Unlock()
+	workerPool := make(chan struct{}, job.options.parallelism)
+	wg := sync.WaitGroup{}
+	for _, i := range indexes {
+		if ctx.Err() != nil {
+			break
+		}
+		workerPool <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-workerPool }()
+			defer wg.Done()
+			size, err := fetchContentLength(ctx, job.links[i].Link)
+			if err != nil {
+				job.itemLogger(i).Errorf("Failed to check the size: %v\n", err)
+				return
+			}
+			job.run.items[i].contentLength.Store(size)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// enqueue adds items to the back of the queue, starting workers as needed.
+func (job *downloadJob) enqueue(indexes ...int) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.queue = append(job.queue, indexes...)
+	job.startWorkers()
+}
+
+// startWorkers starts as many workers as the queue needs, unless the job is paused. The job must be locked.
+func (job *downloadJob) startWorkers() {
+	for !job.paused && job.workers < job.options.parallelism && job.workers < len(job.queue) {
+		job.workers++
+		go job.work(job.ctx)
+	}
+}
+
+// pause stops the job from starting any more downloads, and puts the ones in progress back at the front of the
+// queue. They start over when the job is resumed.
+func (job *downloadJob) pause() {
+	job.lock.Lock()
+	job.paused = true
+	job.lock.Unlock()
+	for _, item := range job.run.items {
+		if item.getStatus() == statusInProgress {
+			item.cancelDownload()
+		}
+	}
+}
+
+func (job *downloadJob) resume() {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.paused = false
+	job.startWorkers()
+}
+
+// activeWorkers returns the number of workers of the job.
+func (job *downloadJob) activeWorkers() int {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	return job.workers
+}
+
+// cancelled tells whether the job was cancelled as a whole, rather than just some of its items.
+func (job *downloadJob) cancelled() bool {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	return job.ctx.Err() != nil
+}
+
+// cancelAll cancels the downloads in progress and everything still queued.
+func (job *downloadJob) cancelAll() {
+	job.lock.Lock()
+	if job.cancel != nil {
+		job.cancel()
+	}
+	job.paused = false
+	// Without workers (i.e. when paused), nothing would pick the queue up and cancel it.
+	idle := job.workers == 0 && len(job.queue) > 0
+	if idle {
+		for _, i := range job.queue {
+			job.run.items[i].status.CompareAndSwap(statusQueued, statusCancelled)
+		}
+		job.queue = nil
+		job.finished = true
+	}
+	job.lock.Unlock()
+	if idle {
+		job.finish()
+	}
+}
+
+// cancelItem cancels a single item, whether it's queued or in progress, without affecting the rest of the job.
+func (job *downloadJob) cancelItem(i int) {
+	job.lock.Lock()
+	job.removeFromQueue(i)
+	job.lock.Unlock()
+	item := job.run.items[i]
+	if !item.status.CompareAndSwap(statusQueued, statusCancelled) {
+		item.cancelDownload()
+	}
+}
+
+// retry downloads a finished item again, even if it already exists, starting the job again if it finished or was
+// cancelled. It returns false if the item isn't finished.
+func (job *downloadJob) retry(i int) bool {
+	item := job.run.items[i]
+	if status := item.getStatus(); status == statusQueued || status == statusInProgress {
+		return false
+	}
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	if job.workers == 0 && job.ctx.Err() != nil {
+		// The job was cancelled, and has since wound down.
+		job.ctx, job.cancel = context.WithCancel(context.Background())
+	}
+	job.requeue(i)
+	return true
+}
+
+// retryFailed downloads the failed items of the job again, and returns how many there were. Unlike retry, it never
+// starts the job again: it returns false, retrying nothing, once the job finished or was cancelled, for owners that
+// consider the job over by then.
+func (job *downloadJob) retryFailed() (int, bool) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	if job.finished || job.ctx.Err() != nil {
+		return 0, false
+	}
+	retried := 0
+	for i, item := range job.run.items {
+		if item.getStatus() == statusFailed {
+			job.requeue(i)
+			retried++
+		}
+	}
+	return retried, true
+}
+
+// requeue queues a finished item to be downloaded again, even if it already exists. The job must be locked.
+func (job *downloadJob) requeue(i int) {
+	item := job.run.items[i]
+	item.reset()
+	item.force.Store(true)
+	job.finished = false
+	job.queue = append(job.queue, i)
+	job.startWorkers()
+}
+
+// prioritize moves a queued item to the front of the queue. It returns false if the item isn't queued.
+func (job *downloadJob) prioritize(i int) bool {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	if !job.removeFromQueue(i) {
+		return false
+	}
+	job.queue = append([]int{i}, job.queue...)
+	return true
+}
+
+func (job *downloadJob) removeFromQueue(i int) bool {
+	for j, queued := range job.queue {
+		if queued == i {
+			job.queue = append(job.queue[:j], job.queue[j+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (job *downloadJob) work(ctx context.Context) {
+	for {
+		i, ok := job.next(ctx)
+		if !ok {
+			return
+		}
+		job.process(ctx, i)
+	}
+}
+
+// next pops the next item off the queue. When there's nothing left to do, it releases the calling worker and returns
+// false.
+func (job *downloadJob) next(ctx context.Context) (int, bool) {
+	job.lock.Lock()
+	if ctx.Err() != nil {
+		for _, i := range job.queue {
+			job.run.items[i].status.CompareAndSwap(statusQueued, statusCancelled)
+		}
+		job.queue = nil
+	}
+	if len(job.queue) == 0 || job.paused {
+		job.workers--
+		finished := job.workers == 0 && len(job.queue) == 0
+		job.finished = job.finished || finished
+		job.lock.Unlock()
+		if finished {
+			job.finish()
+		}
+		return 0, false
+	}
+	i := job.queue[0]
+	job.queue = job.queue[1:]
+	job.lock.Unlock()
+	return i, true
+}
+
+// finish saves the manifest and reports that the job has nothing left to do, once its last worker exits or when it's
+// cancelled without any.
+func (job *downloadJob) finish() {
+	if err := job.manifest.save(); err != nil {
+		logger.Errorf("Failed to save manifest: %v\n", err)
+	}
+	if job.onFinish != nil {
+		job.onFinish()
+	}
+}
+
+func (job *downloadJob) process(ctx context.Context, i int) {
+	item := job.run.items[i]
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	item.cancel.Store(cancel)
+	if !item.status.CompareAndSwap(statusQueued, statusInProgress) {
+		// Cancelled while it was queued.
+		return
+	}
+	force := item.force.Swap(false)
+	log := job.itemLogger(i)
+
+	if job.options.skipExisting && !force {
+		downloaded, reason := job.isDownloaded(ctx, i)
+		if downloaded {
+			log.With("status", statusSkipped).Infof("Already exists. Skipping...\n")
+			job.writeSidecar(i)
+			item.setStatus(statusSkipped)
+			return
+		}
+		if reason != "" {
+			log.Warnf("Will be downloaded again: %s\n", reason)
+		}
+	}
+
+	wc := &WriteCounter{
+		Progress: item,
+		Hash:     sha256.New(),
+	}
+	attempt := item.attempts.Add(1)
+	if attempt > 1 {
+		metrics.retries.Add(1)
+	}
+	log = log.With("attempt", attempt)
+	log.Debugf("Downloading...\n")
+	start := time.Now()
+	err := downloadFile(ctx, job.links[i].Link, item.path, wc)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			job.lock.Lock()
+			defer job.lock.Unlock()
+			if job.paused && job.ctx.Err() == nil {
+				log.Infof("Download paused.\n")
+				item.reset()
+				job.queue = append([]int{i}, job.queue...)
+				return
+			}
+			log.With("status", statusCancelled).Infof("Download cancelled.\n")
+			item.setStatus(statusCancelled)
+			return
+		}
+		log.With("status", statusFailed).Errorf("Failed to download: %v\n", err)
+		item.fail(err)
+	} else {
+		log.With("status", statusSucceeded, "size", wc.Total).Infof("Downloaded successfully.\n")
+		metrics.observeDownload(time.Since(start))
+		job.manifest.record(item.name, manifestEntry{
+			Link:         unsignedLink(job.links[i].Link),
+			Size:         wc.Total,
+			SHA256:       hex.EncodeToString(wc.Hash.Sum(nil)),
+			DownloadedAt: time.Now(),
+		})
+		job.writeSidecar(i)
+		item.setStatus(statusSucceeded)
+	}
+}
+
+// itemLogger returns a logger for the entries about an item, which are tagged with its file name and link.
+func (job *downloadJob) itemLogger(i int) *appLogger {
+	return logger.With("file", job.run.items[i].name, "link", job.links[i].Link)
+}
+
+// writeSidecar saves the details of an item's post next to its video, if the job is set to.
+func (job *downloadJob) writeSidecar(i int) {
+	if !job.options.writeSidecars {
+		return
+	}
+	item := job.run.items[i]
+	if err := writeSidecar(item.path, job.links[i]); err != nil {
+		job.itemLogger(i).Errorf("Failed to save the details: %v\n", err)
+	}
+}
+
+// isDownloaded tells whether an item was already downloaded, according to the job's skip mode. If there is a file
+// that doesn't pass the check, it also returns the reason why.
+func (job *downloadJob) isDownloaded(ctx context.Context, i int) (bool, string) {
+	item := job.run.items[i]
+	info, err := os.Stat(item.path)
+	if err != nil {
+		return false, ""
+	}
+	if info.Size() == 0 {
+		return false, "the existing file is empty"
+	}
+	switch job.options.skipMode {
+	case skipIfSizeMatches:
+		size, err := fetchContentLength(ctx, job.links[i].Link)
+		if err != nil {
+			job.itemLogger(i).Errorf("Failed to check the size, keeping the existing file: %v\n", err)
+			return true, ""
+		}
+		if size != info.Size() {
+			return false, fmt.Sprintf("the existing file is %d bytes, but the video is %d bytes", info.Size(), size)
+		}
+	case skipIfHashMatches:
+		entry, ok := job.manifest.get(item.name)
+		if !ok {
+			return false, "the existing file isn't in the manifest"
+		}
+		hash, err := hashFile(item.path)
+		if err != nil {
+			return false, fmt.Sprintf("failed to read the existing file: %v", err)
+		}
+		if hash != entry.SHA256 {
+			return false, "the existing file's checksum doesn't match the manifest"
+		}
+	}
+	return true, ""
+}
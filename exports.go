@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/ncruces/zenity"
+)
+
+// exportInput is an export file to download, tagged with the account it's from.
+type exportInput struct {
+	Path    string `json:"path"`
+	Type    string `json:"type"`
+	Account string `json:"account"` // Optional. Videos of an account are saved to a folder of their own.
+}
+
+// parsedExport is an export file along with its links.
+type parsedExport struct {
+	exportInput
+	Links  []VideoLink
+	Report *parseReport
+}
+
+// accountDirName returns the name of the folder an account's videos are saved to, or "" if there is no account.
+func accountDirName(account string) string {
+	return sanitizeFileName(account)
+}
+
+// sanitizeFileName replaces the characters that can't be in file names on some systems.
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.Trim(name, " .")
+}
+
+// videoName returns the path of the file a video is saved to with the given file name template (the default one if
+// it's empty), relative to the output directory and with forward slashes. It's how videos are named in the list, the
+// manifest and the catalog.
+func videoName(link VideoLink, template string) string {
+	if dir := accountDirName(link.Account); dir != "" {
+		return path.Join(dir, videoFileName(link, template))
+	}
+	return videoFileName(link, template)
+}
+
+// videoPath returns the path of the file a video is saved to.
+func videoPath(outputDir string, link VideoLink, template string) string {
+	return filepath.Join(outputDir, filepath.FromSlash(videoName(link, template)))
+}
+
+// readExports reads and parses several exports, and merges their links into a single list. A post that's in more
+// than one export (identified by postKey, like in the catalog) is only listed once, with the link and metadata of the
+// latest export it's in, since the links of older ones may have expired.
+func readExports(inputs []exportInput) ([]VideoLink, []parsedExport, error) {
+	if len(inputs) == 0 {
+		return nil, nil, fmt.Errorf("You must select an input file.")
+	}
+	var links []VideoLink
+	var exports []parsedExport
+	// The index in links of each post seen so far, and when the export it was taken from was made.
+	type seenPost struct {
+		index      int
+		exportedAt time.Time
+	}
+	seen := map[string]seenPost{}
+	duplicates := 0
+	for _, input := range inputs {
+		exportLinks, report, err := readAndParseFile(input.Path, input.Type)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", filepath.Base(input.Path), err)
+		}
+		// Exports are dated like in the catalog (see importExport).
+		info, err := os.Stat(input.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", filepath.Base(input.Path), err)
+		}
+		exportedAt := info.ModTime()
+		for i := range exportLinks {
+			exportLinks[i].Account = input.Account
+			key := postKey(exportLinks[i])
+			if post, ok := seen[key]; ok {
+				duplicates++
+				if exportedAt.After(post.exportedAt) {
+					links[post.index].Link = exportLinks[i].Link
+					links[post.index].Metadata = exportLinks[i].Metadata
+					seen[key] = seenPost{index: post.index, exportedAt: exportedAt}
+				}
+				continue
+			}
+			seen[key] = seenPost{index: len(links), exportedAt: exportedAt}
+			links = append(links, exportLinks[i])
+		}
+		exports = append(exports, parsedExport{exportInput: input, Links: exportLinks, Report: report})
+	}
+	if len(inputs) > 1 {
+		logger.Infof("Merged %d exports into %d posts (%d duplicates)\n", len(inputs), len(links), duplicates)
+		sortLinksByDateDescending(links)
+	}
+	return links, exports, nil
+}
+
+// inputs returns every export selected for download: the input file, and any additional exports.
+func (appState *appState) inputs() []exportInput {
+	var inputs []exportInput
+	inputFile, _ := appState.inputFile.Get()
+	fileType, _ := appState.fileType.Get()
+	account, _ := appState.inputAccount.Get()
+	if inputFile != "" {
+		inputs = append(inputs, exportInput{Path: inputFile, Type: fileType, Account: strings.TrimSpace(account)})
+	}
+	return append(inputs, appState.extraInputs()...)
+}
+
+func (appState *appState) extraInputs() []exportInput {
+	var inputs []exportInput
+	if content, _ := appState.moreInputs.Get(); content != "" {
+		if err := json.Unmarshal([]byte(content), &inputs); err != nil {
+			logger.Errorf("Failed to read additional exports: %v\n", err)
+		}
+	}
+	return inputs
+}
+
+func (appState *appState) setExtraInputs(inputs []exportInput) {
+	content, err := json.Marshal(inputs)
+	if err != nil {
+		logger.Errorf("Failed to save additional exports: %v\n", err)
+		return
+	}
+	appState.moreInputs.Set(string(content))
+}
+
+// showExportsDialog lets the user add more exports to download along with the input file, e.g. from other accounts
+// or dates.
+func showExportsDialog(appState *appState) {
+	inputs := appState.extraInputs()
+	rows := container.NewVBox()
+	var refresh func()
+	refresh = func() {
+		rows.RemoveAll()
+		if len(inputs) == 0 {
+			rows.Add(widget.NewLabel("No additional exports."))
+		}
+		for i := range inputs {
+			i := i
+			account := widget.NewEntry()
+			account.SetPlaceHolder("Account (optional)")
+			account.SetText(inputs[i].Account)
+			account.OnChanged = func(text string) {
+				inputs[i].Account = strings.TrimSpace(text)
+			}
+			removeButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				inputs = append(inputs[:i], inputs[i+1:]...)
+				refresh()
+			})
+			name := widget.NewLabel(fmt.Sprintf("%s (%s)", filepath.Base(inputs[i].Path), filepath.Base(filepath.Dir(inputs[i].Path))))
+			rows.Add(container.NewBorder(nil, nil, name, removeButton, account))
+		}
+	}
+	refresh()
+
+	addButton := widget.NewButtonWithIcon("Add export...", theme.ContentAddIcon(), func() {
+		paths, err := zenity.SelectFileMultiple(
+			zenity.Title("Select additional exports"),
+			zenity.FileFilters{
+				{Name: "JSON files", Patterns: []string{"*.json"}, CaseFold: false},
+				{Name: "Text files", Patterns: []string{"*.txt"}, CaseFold: false},
+			},
+		)
+		if err != nil {
+			if err != zenity.ErrCanceled {
+				logger.Errorf("Error selecting file: %v", err)
+			}
+			return
+		}
+		for _, file := range paths {
+			inputs = append(inputs, exportInput{Path: file, Type: detectFileType(file)})
+		}
+		refresh()
+	})
+	message := widget.NewLabel("These exports are downloaded along with the input file. Posts that are in more than one export are only downloaded once, and the videos of each account go to a folder of their own.")
+	message.Wrapping = fyne.TextWrapWord
+	content := container.NewBorder(message, container.NewHBox(addButton, layout.NewSpacer()), nil, nil, container.NewVScroll(rows))
+	d := dialog.NewCustomConfirm("More exports", "Save", "Cancel", content, func(ok bool) {
+		if ok {
+			appState.setExtraInputs(inputs)
+		}
+	}, appState.window)
+	d.Resize(fyne.NewSize(600, 400))
+	d.Show()
+}
+
+// confirmParseWarnings shows the warnings of the exports, if any, and asks whether to download them anyway. It blocks
+// until the user answers, so it must not be called from the UI's goroutine.
+func confirmParseWarnings(appState *appState, exports []parsedExport) bool {
+	var warnings []string
+	for _, export := range exports {
+		for _, warning := range export.Report.Warnings {
+			warnings = append(warnings, fmt.Sprintf("%s, %s", filepath.Base(export.Path), warning))
+		}
+	}
+	if len(warnings) == 0 {
+		return true
+	}
+	list := widget.NewList(
+		func() int {
+			return len(warnings)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("")
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			obj.(*widget.Label).SetText(warnings[id])
+		},
+	)
+	message := widget.NewLabel(fmt.Sprintf("%d problems were found while reading the input files. The posts they affect won't be downloaded.", len(warnings)))
+	message.Wrapping = fyne.TextWrapWord
+	answer := make(chan bool, 1)
+	d := dialog.NewCustomConfirm("Problems in the export", "Download anyway", "Cancel", container.NewBorder(message, nil, nil, nil, list), func(ok bool) {
+		answer <- ok
+	}, appState.window)
+	d.Resize(fyne.NewSize(700, 400))
+	d.Show()
+	return <-answer
+}
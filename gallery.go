@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2/dialog"
+
+	"github.com/skratchdot/open-golang/open"
+)
+
+// The gallery is a set of static HTML pages in the output directory to browse the archived videos with, even
+// without TikTok Archiver: index.html, and a page per year, e.g. 2022.html.
+
+// galleryGenerator marks the pages of the gallery, so that an index.html that isn't one is never overwritten.
+const galleryGenerator = "TikTok Archiver gallery"
+
+var galleryPagePattern = regexp.MustCompile(`^(index|\d{4}|Unknown)\.html$`)
+
+// isGalleryPage tells whether name, relative to the output directory, could be a page of the gallery.
+func isGalleryPage(name string) bool {
+	return galleryPagePattern.MatchString(name)
+}
+
+// galleryVideoURL returns the relative link to a video from its path, escaping each folder and file name so that
+// characters like # or ? in them don't end the path.
+func galleryVideoURL(fileName string) template.URL {
+	segments := strings.Split(fileName, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return template.URL(strings.Join(segments, "/"))
+}
+
+// galleryPost is a video on a page of the gallery.
+type galleryPost struct {
+	Anchor  string       // ID of the post on its page.
+	Page    string       // Page of its year.
+	Video   template.URL // Link to the video, relative to the output directory.
+	Date    string
+	Year    string
+	Caption string
+	Likes   int
+	Account string
+	Deleted bool
+}
+
+type galleryYear struct {
+	Year  string
+	Page  string
+	Count int
+}
+
+// postYear returns the year of a post's date, which names its page of the gallery, or "Unknown" if the date doesn't
+// start with one.
+func postYear(date string) string {
+	if len(date) >= 4 {
+		if year, err := strconv.Atoi(date[:4]); err == nil && year >= 1970 && year <= 9999 {
+			return date[:4]
+		}
+	}
+	return "Unknown"
+}
+
+// buildGallery writes the gallery of every post of the catalog whose video is in the output directory. Pages are only
+// written if they changed, so that it's cheap to run after every download.
+func buildGallery(outputDir string) error {
+	c, err := loadCatalog(outputDir)
+	if err != nil {
+		return err
+	}
+	byYear := map[string][]galleryPost{}
+	for _, post := range c.Posts {
+		if _, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(post.FileName))); err != nil {
+			continue
+		}
+		link := VideoLink{Date: post.Date, Link: post.Link, Account: post.Account, Metadata: post.Metadata}
+		year := postYear(post.Date)
+		likes, _ := strconv.Atoi(strings.ReplaceAll(link.field("likes"), ",", ""))
+		byYear[year] = append(byYear[year], galleryPost{
+			Anchor:  "post-" + strings.NewReplacer("/", "-", " ", "-", ".", "-").Replace(post.FileName),
+			Page:    year + ".html",
+			Video:   galleryVideoURL(post.FileName),
+			Date:    post.Date,
+			Year:    year,
+			Caption: link.field("caption"),
+			Likes:   likes,
+			Account: post.Account,
+			Deleted: post.Deleted,
+		})
+	}
+
+	var years []galleryYear
+	var all []galleryPost
+	for year, posts := range byYear {
+		sort.Slice(posts, func(i, j int) bool {
+			return posts[i].Date > posts[j].Date
+		})
+		years = append(years, galleryYear{Year: year, Page: year + ".html", Count: len(posts)})
+		all = append(all, posts...)
+	}
+	sort.Slice(years, func(i, j int) bool {
+		return years[i].Year > years[j].Year
+	})
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Date > all[j].Date
+	})
+
+	written := 0
+	write := func(name string, page string, data interface{}) error {
+		var content bytes.Buffer
+		if err := galleryTemplates.ExecuteTemplate(&content, page, data); err != nil {
+			return err
+		}
+		path := filepath.Join(outputDir, name)
+		existing, err := os.ReadFile(path)
+		if err == nil {
+			if bytes.Equal(existing, content.Bytes()) {
+				return nil
+			}
+			if !bytes.Contains(existing, []byte(galleryGenerator)) {
+				logger.Warnf("Not writing the gallery's %s, there's already a file by that name\n", name)
+				return nil
+			}
+		}
+		written++
+		return os.WriteFile(path, content.Bytes(), 0666)
+	}
+	if err := write("index.html", "index", map[string]interface{}{"Years": years, "Posts": all}); err != nil {
+		return err
+	}
+	for _, year := range years {
+		err := write(year.Page, "year", map[string]interface{}{"Year": year.Year, "Years": years, "Posts": byYear[year.Year]})
+		if err != nil {
+			return err
+		}
+	}
+	logger.Infof("Updated the gallery in %s: %d videos, %d pages changed\n", outputDir, len(all), written)
+	return nil
+}
+
+// openGallery updates the gallery of the output directory, and opens it in the browser.
+func openGallery(appState *appState) {
+	outputDir, _ := appState.outputDir.Get()
+	if outputDir == "" {
+		dialog.ShowError(fmt.Errorf("You must select a folder first."), appState.window)
+		return
+	}
+	go func() {
+		if err := buildGallery(outputDir); err != nil {
+			logger.Errorf("Failed to update the gallery: %v\n", err)
+			dialog.ShowError(err, appState.window)
+			return
+		}
+		if err := open.Run(filepath.Join(outputDir, "index.html")); err != nil {
+			logger.Errorf("Failed to open the gallery: %v\n", err)
+		}
+	}()
+}
+
+var galleryTemplates = template.Must(template.New("gallery").Funcs(template.FuncMap{
+	"generator": func() string { return galleryGenerator },
+	"lower":     strings.ToLower,
+	"plural": func(n int, word string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, word)
+		}
+		return fmt.Sprintf("%d %ss", n, word)
+	},
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="generator" content="{{generator}}">
+<title>{{.}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; background: #111; color: #eee; }
+header { position: sticky; top: 0; background: #111; padding: 12px 20px; border-bottom: 1px solid #333; display: flex; flex-wrap: wrap; gap: 12px; align-items: center; }
+header h1 { font-size: 20px; margin: 0 12px 0 0; }
+a { color: #6cf; }
+nav a { margin-right: 10px; }
+input, select { background: #222; color: #eee; border: 1px solid #444; padding: 6px; border-radius: 4px; }
+main { padding: 20px; }
+.posts { display: grid; grid-template-columns: repeat(auto-fill, minmax(240px, 1fr)); gap: 20px; }
+.post { background: #1b1b1b; border-radius: 8px; overflow: hidden; }
+.post video { width: 100%; aspect-ratio: 9 / 16; background: #000; }
+.post .details { padding: 8px 10px; font-size: 14px; }
+.post .caption { margin-top: 4px; white-space: pre-wrap; word-wrap: break-word; }
+.post .meta { color: #999; font-size: 12px; }
+.deleted { color: #f88; }
+.results li { margin: 4px 0; }
+[hidden] { display: none !important; }
+</style>
+</head>
+{{end}}
+
+{{define "nav"}}<nav>{{range .}}<a href="{{.Page}}">{{.Year}}</a>{{end}}</nav>{{end}}
+
+{{define "index"}}{{template "head" "TikTok archive"}}
+<body>
+<header>
+<h1><a href="index.html">TikTok archive</a></h1>
+{{template "nav" .Years}}
+<input id="search" type="search" placeholder="Search captions and dates" autofocus>
+</header>
+<main>
+<ul id="years">
+{{range .Years}}<li><a href="{{.Page}}">{{.Year}}</a>: {{plural .Count "video"}}</li>
+{{end}}</ul>
+<ul id="results" class="results" hidden></ul>
+</main>
+<script>
+const posts = {{.Posts}};
+const search = document.getElementById("search");
+const results = document.getElementById("results");
+search.addEventListener("input", () => {
+  const query = search.value.trim().toLowerCase();
+  document.getElementById("years").hidden = query !== "";
+  results.hidden = query === "";
+  results.replaceChildren();
+  if (query === "") {
+    return;
+  }
+  for (const post of posts) {
+    if (!(post.Date + " " + post.Caption + " " + post.Account).toLowerCase().includes(query)) {
+      continue;
+    }
+    const item = document.createElement("li");
+    const link = document.createElement("a");
+    link.href = post.Page + "#" + post.Anchor;
+    link.textContent = post.Date;
+    item.append(link, post.Caption ? " " + post.Caption : "");
+    results.append(item);
+  }
+});
+</script>
+</body>
+</html>
+{{end}}
+
+{{define "year"}}{{template "head" (print "TikTok archive, " .Year)}}
+<body>
+<header>
+<h1><a href="index.html">TikTok archive</a>, {{.Year}}</h1>
+{{template "nav" .Years}}
+<input id="search" type="search" placeholder="Search captions and dates">
+<select id="sort">
+<option value="newest">Newest first</option>
+<option value="oldest">Oldest first</option>
+<option value="likes">Most liked first</option>
+</select>
+</header>
+<main>
+<div id="posts" class="posts">
+{{range .Posts}}<div class="post" id="{{.Anchor}}" data-date="{{.Date}}" data-likes="{{.Likes}}" data-search="{{lower .Date}} {{lower .Caption}} {{lower .Account}}">
+<video src="{{.Video}}" controls preload="none"></video>
+<div class="details">
+<div class="meta">{{.Date}}{{if .Account}} · {{.Account}}{{end}} · {{plural .Likes "like"}}{{if .Deleted}} · <span class="deleted">Deleted from TikTok</span>{{end}}</div>
+{{if .Caption}}<div class="caption">{{.Caption}}</div>{{end}}
+</div>
+</div>
+{{end}}</div>
+</main>
+<script>
+const container = document.getElementById("posts");
+const posts = Array.from(container.children);
+document.getElementById("search").addEventListener("input", (event) => {
+  const query = event.target.value.trim().toLowerCase();
+  for (const post of posts) {
+    post.hidden = !post.dataset.search.includes(query);
+  }
+});
+document.getElementById("sort").addEventListener("change", (event) => {
+  const compare = {
+    newest: (a, b) => b.dataset.date.localeCompare(a.dataset.date),
+    oldest: (a, b) => a.dataset.date.localeCompare(b.dataset.date),
+    likes: (a, b) => Number(b.dataset.likes) - Number(a.dataset.likes),
+  }[event.target.value];
+  container.append(...posts.slice().sort(compare));
+});
+</script>
+</body>
+</html>
+{{end}}
+`))
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/dustin/go-humanize"
+)
+
+// historyFileName is the file of the app's data folder where past runs are kept.
+const historyFileName = "history.json"
+
+// Only the most recent runs are kept, each with its first errors.
+const (
+	maxHistoryRuns   = 200
+	maxHistoryErrors = 100
+)
+
+// Outcomes of a run.
+const (
+	runFinished  = "finished"
+	runCancelled = "cancelled"
+)
+
+// historyRun is a download started from the window, along with its results.
+type historyRun struct {
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt time.Time     `json:"finishedAt"`
+	Inputs     []exportInput `json:"inputs"`
+	OutputDir  string        `json:"outputDir"`
+	Options    daemonOptions `json:"options"`
+	Outcome    string        `json:"outcome"`
+
+	Total          int            `json:"total"`
+	Statuses       map[string]int `json:"statuses"`
+	BytesDone      int64          `json:"bytesDone"`
+	BytesPerSecond int64          `json:"bytesPerSecond"` // On average, over the whole run.
+	Errors         []historyError `json:"errors,omitempty"`
+}
+
+// historyError is a video that failed to download in a run. Signatures are left out of its link and error.
+type historyError struct {
+	Name  string `json:"name"`
+	Link  string `json:"link"`
+	Error string `json:"error"`
+}
+
+// newHistoryRun describes a run as it is at the moment. cancelled tells whether the whole run was cancelled: items
+// cancelled one by one don't make it so.
+func newHistoryRun(startedAt time.Time, inputs []exportInput, outputDir string, options daemonOptions, run *runProgress,
+	cancelled bool) historyRun {
+	items := run.itemSnapshots()
+	counters := run.counters(items)
+	h := historyRun{
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+		Inputs:     inputs,
+		OutputDir:  outputDir,
+		Options:    options,
+		Outcome:    runFinished,
+		Total:      counters.Total,
+		Statuses:   map[string]int{},
+		BytesDone:  counters.BytesDone,
+	}
+	for i, status := range allStatuses {
+		if count := counters.StatusCounts[i]; count > 0 {
+			h.Statuses[status] = count
+		}
+	}
+	if cancelled {
+		h.Outcome = runCancelled
+	}
+	if seconds := h.FinishedAt.Sub(startedAt).Seconds(); seconds > 0 {
+		h.BytesPerSecond = int64(float64(h.BytesDone) / seconds)
+	}
+	for _, item := range items {
+		if item.Status == statusFailed && len(h.Errors) < maxHistoryErrors {
+			h.Errors = append(h.Errors, historyError{Name: item.Name, Link: unsignedLink(item.Link), Error: redact(item.Error)})
+		}
+	}
+	return h
+}
+
+// summary describes the results of the run in a line.
+func (h historyRun) summary() string {
+	text := fmt.Sprintf("%s: %d / %d videos", h.StartedAt.Format("2006-01-02 15:04"), h.Statuses[statusSucceeded]+h.Statuses[statusSkipped], h.Total)
+	if failed := h.Statuses[statusFailed]; failed > 0 {
+		text += fmt.Sprintf(", %d errors", failed)
+	}
+	if h.Outcome == runCancelled {
+		text += ", cancelled"
+	}
+	return text
+}
+
+// details describes everything about the run, for the history view.
+func (h historyRun) details() string {
+	var inputs []string
+	for _, input := range h.Inputs {
+		text := input.Path
+		if input.Account != "" {
+			text += fmt.Sprintf(" (%s)", input.Account)
+		}
+		inputs = append(inputs, text)
+	}
+	var statuses []string
+	for _, status := range allStatuses {
+		if count := h.Statuses[status]; count > 0 {
+			statuses = append(statuses, fmt.Sprintf("%d %s", count, status))
+		}
+	}
+	lines := []string{
+		fmt.Sprintf("Started %s, %s after %s", h.StartedAt.Format("2006-01-02 15:04:05"), h.Outcome, h.FinishedAt.Sub(h.StartedAt).Round(time.Second)),
+		"Read from: " + strings.Join(inputs, ", "),
+		"Downloaded to: " + h.OutputDir,
+		"Options: " + h.Options.summary(),
+		fmt.Sprintf("%d videos: %s", h.Total, strings.Join(statuses, ", ")),
+		fmt.Sprintf("%s downloaded at %s/s on average", humanize.Bytes(uint64(h.BytesDone)), humanize.Bytes(uint64(h.BytesPerSecond))),
+	}
+	if len(h.Errors) > 0 {
+		lines = append(lines, "", "Errors:")
+		for _, e := range h.Errors {
+			lines = append(lines, fmt.Sprintf("%s: %s", e.Name, e.Error))
+		}
+		if failed := h.Statuses[statusFailed]; failed > len(h.Errors) {
+			lines = append(lines, fmt.Sprintf("and %d more", failed-len(h.Errors)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runHistory is the list of past runs, saved in the app's data folder.
+type runHistory struct {
+	path string
+	lock sync.Mutex
+
+	Runs []historyRun `json:"runs"` // Oldest first.
+}
+
+// loadRunHistory reads the history saved at path, returning an empty one if there isn't any yet.
+func loadRunHistory(path string) (*runHistory, error) {
+	h := &runHistory{path: path}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+	if err := json.Unmarshal(content, h); err != nil {
+		return h, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return h, nil
+}
+
+// record adds a run to the history and saves it. A run that's already in the history (e.g. after some of its videos
+// were retried) is replaced.
+func (h *runHistory) record(run historyRun) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	replaced := false
+	for i := range h.Runs {
+		if h.Runs[i].StartedAt.Equal(run.StartedAt) {
+			h.Runs[i] = run
+			replaced = true
+		}
+	}
+	if !replaced {
+		h.Runs = append(h.Runs, run)
+	}
+	if len(h.Runs) > maxHistoryRuns {
+		h.Runs = h.Runs[len(h.Runs)-maxHistoryRuns:]
+	}
+	if err := h.save(); err != nil {
+		logger.Errorf("Failed to save the run history: %v\n", err)
+	}
+}
+
+func (h *runHistory) save() error {
+	content, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0777); err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that the history isn't lost if writing fails halfway.
+	temp := h.path + ".temp"
+	if err := os.WriteFile(temp, content, 0666); err != nil {
+		return err
+	}
+	return os.Rename(temp, h.path)
+}
+
+// list returns the runs, most recent first.
+func (h *runHistory) list() []historyRun {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	runs := append([]historyRun{}, h.Runs...)
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return runs
+}
+
+// runOptions returns the options of the window, in the form they're saved in the history.
+func (appState *appState) runOptions() daemonOptions {
+	options := appState.jobOptions()
+	onlyNew, _ := appState.onlyNew.Get()
+	fileNames, _ := appState.fileNames.Get()
+	gallery, _ := appState.gallery.Get()
+	return daemonOptions{
+		SkipExisting:  options.skipExisting,
+		SkipMode:      options.skipMode,
+		Parallelism:   options.parallelism,
+		Order:         options.order,
+		OnlyNew:       onlyNew,
+		WriteSidecars: options.writeSidecars,
+		FileNames:     fileNames,
+		Gallery:       gallery,
+	}
+}
+
+// applyRun sets the inputs, output folder and options of the window back to those of a past run.
+func (appState *appState) applyRun(run historyRun) {
+	inputFile, fileType, account := "", "", ""
+	var moreInputs []exportInput
+	if len(run.Inputs) > 0 {
+		inputFile, fileType, account = run.Inputs[0].Path, run.Inputs[0].Type, run.Inputs[0].Account
+		moreInputs = run.Inputs[1:]
+	}
+	appState.inputFile.Set(inputFile)
+	appState.fileType.Set(fileType)
+	appState.inputAccount.Set(account)
+	content, _ := json.Marshal(moreInputs)
+	appState.moreInputs.Set(string(content))
+	appState.outputDir.Set(run.OutputDir)
+
+	appState.skipExisting.Set(run.Options.SkipExisting)
+	appState.skipMode.Set(run.Options.SkipMode)
+	appState.parallelism.Set(float64(run.Options.Parallelism))
+	appState.order.Set(run.Options.Order)
+	appState.onlyNew.Set(run.Options.OnlyNew)
+	appState.sidecars.Set(run.Options.WriteSidecars)
+	appState.fileNames.Set(run.Options.FileNames)
+	appState.gallery.Set(run.Options.Gallery)
+}
+
+func showHistory(appState *appState) {
+	runs := appState.history.list()
+	if len(runs) == 0 {
+		dialog.ShowInformation("Run history", "Nothing was downloaded yet.", appState.window)
+		return
+	}
+
+	details := widget.NewLabel("Select a run to see its details.")
+	details.Wrapping = fyne.TextWrapWord
+	selected := -1
+	var d dialog.Dialog
+	rerunButton := widget.NewButtonWithIcon("Run again", theme.MediaReplayIcon(), func() {
+		if selected < 0 {
+			return
+		}
+		if isDownloading, _ := appState.isDownloading.Get(); isDownloading {
+			dialog.ShowError(fmt.Errorf("Wait for the current download to finish, or cancel it."), appState.window)
+			return
+		}
+		run := runs[selected]
+		dialog.ShowConfirm("Run again",
+			"This sets the input files, the output folder and the options back to those of this run, and starts downloading.",
+			func(ok bool) {
+				if !ok {
+					return
+				}
+				d.Hide()
+				appState.applyRun(run)
+				downloadFiles(appState)
+			}, appState.window)
+	})
+	rerunButton.Disable()
+
+	list := widget.NewList(
+		func() int {
+			return len(runs)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("")
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			obj.(*widget.Label).SetText(runs[id].summary())
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		selected = id
+		details.SetText(runs[id].details())
+		rerunButton.Enable()
+	}
+
+	split := container.NewHSplit(list, container.NewBorder(nil,
+		container.NewHBox(layout.NewSpacer(), rerunButton), nil, nil,
+		container.NewVScroll(details),
+	))
+	split.SetOffset(0.4)
+	d = dialog.NewCustom("Run history", "Close", split, appState.window)
+	d.Resize(fyne.NewSize(800, 500))
+	d.Show()
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Levels of log entries.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (level logLevel) String() string {
+	return logLevelNames[level]
+}
+
+func parseLogLevel(name string) (logLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return logLevel(level), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown log level %q, must be one of %q", name, logLevelNames)
+}
+
+// Formats that logs can be written in.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Settings of the log, which commands can change with flags (see logFlags).
+var (
+	logFormat   = logFormatText
+	minLogLevel = levelInfo
+)
+
+// Log files are deleted once there are more than maxLogFiles of them, or once they're older than maxLogAge.
+const (
+	maxLogFiles = 50
+	maxLogAge   = 30 * 24 * time.Hour
+)
+
+// signedQueryPattern matches the query strings of URLs, which for TikTok's videos hold signatures and tokens that
+// shouldn't end up in logs that may be shared.
+var signedQueryPattern = regexp.MustCompile(`(https?://[^\s?"'<>]+)\?[^\s"'<>]+`)
+
+// redact removes the query strings of the URLs in s.
+func redact(s string) string {
+	return signedQueryPattern.ReplaceAllString(s, "$1?<redacted>")
+}
+
+// unsignedLink removes the query string of a link, for saving it where its signature isn't needed. What's left still
+// identifies the video, see postKey.
+func unsignedLink(link string) string {
+	return signedQueryPattern.ReplaceAllString(link, "$1")
+}
+
+// logField is a key and value attached to log entries, e.g. the video they're about.
+type logField struct {
+	Key   string
+	Value interface{}
+}
+
+// logEntry is a single entry of the log, with its message and fields already redacted.
+type logEntry struct {
+	Seq     int // Position of the entry in the session's log, see logBuffer.
+	Time    time.Time
+	Level   logLevel
+	Message string
+	Fields  []logField
+}
+
+// field returns the value of one of the entry's fields, or "" if it doesn't have it.
+func (entry logEntry) field(key string) string {
+	for _, field := range entry.Fields {
+		if field.Key == key {
+			return fmt.Sprint(field.Value)
+		}
+	}
+	return ""
+}
+
+// text formats the entry as a line of the text log, without the line break.
+func (entry logEntry) text() string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s %-5s %s", entry.Time.Format("2006/01/02 15:04:05"), strings.ToUpper(entry.Level.String()), entry.Message)
+	for _, field := range entry.Fields {
+		value := fmt.Sprint(field.Value)
+		if value == "" || strings.ContainsAny(value, " \"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&text, " %s=%s", field.Key, value)
+	}
+	return text.String()
+}
+
+// json formats the entry as a line of the JSON log, without the line break.
+func (entry logEntry) json() string {
+	values := map[string]interface{}{"time": entry.Time.Format(time.RFC3339Nano), "level": entry.Level.String(), "msg": entry.Message}
+	for _, field := range entry.Fields {
+		values[field.Key] = field.Value
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(values)
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+// appLogger writes leveled log entries, as text or JSON lines. Loggers derived with With share their output.
+type appLogger struct {
+	core   *logCore
+	fields []logField
+}
+
+type logCore struct {
+	lock  sync.Mutex
+	out   io.Writer
+	json  bool
+	level logLevel
+	// Keeps the session's entries for the log viewer, at every level, if set.
+	buffer *logBuffer
+}
+
+func newAppLogger(out io.Writer, format string, level logLevel) *appLogger {
+	return &appLogger{core: &logCore{out: out, json: format == logFormatJSON, level: level}}
+}
+
+// With returns a logger that adds fields to every entry, given as alternating keys and values.
+func (l *appLogger) With(keyValues ...interface{}) *appLogger {
+	fields := append([]logField{}, l.fields...)
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		fields = append(fields, logField{Key: fmt.Sprint(keyValues[i]), Value: keyValues[i+1]})
+	}
+	return &appLogger{core: l.core, fields: fields}
+}
+
+func (l *appLogger) SetOutput(out io.Writer) {
+	l.core.lock.Lock()
+	defer l.core.lock.Unlock()
+	l.core.out = out
+}
+
+// SetBuffer makes the logger also keep its entries in buffer, including those below its level.
+func (l *appLogger) SetBuffer(buffer *logBuffer) {
+	l.core.lock.Lock()
+	defer l.core.lock.Unlock()
+	l.core.buffer = buffer
+}
+
+func (l *appLogger) Debugf(format string, args ...interface{}) { l.log(levelDebug, format, args...) }
+func (l *appLogger) Infof(format string, args ...interface{})  { l.log(levelInfo, format, args...) }
+func (l *appLogger) Warnf(format string, args ...interface{})  { l.log(levelWarn, format, args...) }
+func (l *appLogger) Errorf(format string, args ...interface{}) { l.log(levelError, format, args...) }
+
+func (l *appLogger) log(level logLevel, format string, args ...interface{}) {
+	l.core.lock.Lock()
+	defer l.core.lock.Unlock()
+	if level < l.core.level && l.core.buffer == nil {
+		return
+	}
+	entry := logEntry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: redact(strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")),
+		Fields:  make([]logField, len(l.fields)),
+	}
+	for i, field := range l.fields {
+		entry.Fields[i] = logField{Key: field.Key, Value: redactValue(field.Value)}
+	}
+	if l.core.buffer != nil {
+		l.core.buffer.add(entry)
+	}
+	if level < l.core.level {
+		return
+	}
+	line := entry.text()
+	if l.core.json {
+		line = entry.json()
+	}
+	io.WriteString(l.core.out, line+"\n")
+}
+
+func redactValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return redact(value)
+	case error:
+		return redact(value.Error())
+	}
+	return value
+}
+
+// logFlags registers the flags that set the format and level of the log.
+func logFlags(flags *flag.FlagSet) {
+	flags.Func("log-format", `the format of the log, "text" or "json" (default "text")`, func(format string) error {
+		if format != logFormatText && format != logFormatJSON {
+			return fmt.Errorf("must be %q or %q", logFormatText, logFormatJSON)
+		}
+		logFormat = format
+		logger = newAppLogger(logger.core.out, logFormat, minLogLevel)
+		return nil
+	})
+	flags.Func("log-level", `the lowest level of the entries to log: "debug", "info", "warn" or "error" (default "info")`, func(name string) error {
+		level, err := parseLogLevel(name)
+		if err != nil {
+			return err
+		}
+		minLogLevel = level
+		logger = newAppLogger(logger.core.out, logFormat, minLogLevel)
+		return nil
+	})
+}
+
+// pruneLogs deletes the oldest log files of dir, according to maxLogFiles and maxLogAge.
+func pruneLogs(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Warnf("Failed to clean up old logs: %v\n", err)
+		return
+	}
+	type logFile struct {
+		name     string
+		modified time.Time
+	}
+	var files []logFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), "log-") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, logFile{entry.Name(), info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modified.After(files[j].modified)
+	})
+	deleted := 0
+	for i, file := range files {
+		if i < maxLogFiles && time.Since(file.modified) < maxLogAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, file.name)); err != nil {
+			logger.Warnf("Failed to delete old log %s: %v\n", file.name, err)
+			continue
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		logger.Debugf("Deleted %d old logs\n", deleted)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/ncruces/zenity"
+)
+
+// Number of entries of the session kept in memory for the log viewer. Older ones are only in the log file.
+const logBufferSize = 5000
+
+// sessionLog keeps the entries logged since the app started, for the log viewer.
+var sessionLog = newLogBuffer(logBufferSize)
+
+// logBuffer keeps the most recent entries of the log.
+type logBuffer struct {
+	lock    sync.RWMutex
+	entries []logEntry
+	limit   int
+	total   int // Entries added so far, including those that were dropped.
+}
+
+func newLogBuffer(limit int) *logBuffer {
+	return &logBuffer{limit: limit}
+}
+
+func (b *logBuffer) add(entry logEntry) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	entry.Seq = b.total
+	b.total++
+	if len(b.entries) == b.limit {
+		copy(b.entries, b.entries[1:])
+		b.entries = b.entries[:len(b.entries)-1]
+	}
+	b.entries = append(b.entries, entry)
+}
+
+// snapshot returns the entries kept, oldest first, and the number of entries added so far.
+func (b *logBuffer) snapshot() ([]logEntry, int) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return append([]logEntry{}, b.entries...), b.total
+}
+
+// Minimum levels the viewer can filter by, as shown in its menu.
+var logLevelLabels = []string{"Debug", "Info", "Warning", "Error"}
+
+// logViewer is a window that tails the session's log. Its entries can be filtered by level and by the file they're
+// about, and a range of them selected to be copied or saved.
+type logViewer struct {
+	window      fyne.Window
+	levelSelect *widget.Select
+	fileEntry   *widget.Entry
+	followCheck *widget.Check
+	countLabel  *widget.Label
+	list        *widget.List
+
+	lock     sync.Mutex
+	shown    []logEntry
+	seen     int      // Total of the buffer when the entries were last filtered.
+	minLevel logLevel // Filters, read from the widgets when they change.
+	file     string
+	// Selected range of entries, by sequence number, or -1. anchor is where the selection started.
+	anchor, cursor int
+
+	stop chan struct{}
+}
+
+// showLogViewer opens the log viewer, or brings it to the front. If file is given, the viewer only shows the entries
+// about that file and scrolls to its latest error (or latest entry, if it had no errors).
+func showLogViewer(appState *appState, file string) {
+	appState.lock.Lock()
+	viewer := appState.logViewer
+	if viewer == nil {
+		viewer = newLogViewer(appState)
+		appState.logViewer = viewer
+	}
+	appState.lock.Unlock()
+
+	if file != "" {
+		viewer.followCheck.SetChecked(false)
+		viewer.levelSelect.SetSelected(logLevelLabels[levelDebug])
+		viewer.fileEntry.SetText(file)
+		viewer.jumpToLatest()
+	}
+	viewer.window.Show()
+	viewer.window.RequestFocus()
+}
+
+func newLogViewer(appState *appState) *logViewer {
+	viewer := &logViewer{
+		window:   fyne.CurrentApp().NewWindow("Log"),
+		minLevel: levelInfo,
+		anchor:   -1,
+		cursor:   -1,
+		seen:     -1,
+		stop:     make(chan struct{}),
+	}
+	viewer.countLabel = widget.NewLabel("")
+	viewer.list = widget.NewList(
+		func() int {
+			viewer.lock.Lock()
+			defer viewer.lock.Unlock()
+			return len(viewer.shown)
+		},
+		func() fyne.CanvasObject {
+			return newLogRow(viewer)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			viewer.lock.Lock()
+			if id >= len(viewer.shown) {
+				viewer.lock.Unlock()
+				return
+			}
+			entry := viewer.shown[id]
+			selected := viewer.isSelected(entry.Seq)
+			viewer.lock.Unlock()
+			obj.(*logRow).setEntry(entry, selected)
+		},
+	)
+
+	// The filters are set up after the list, since setting them redraws it.
+	viewer.followCheck = widget.NewCheck("Follow", func(follow bool) {
+		if follow {
+			viewer.list.ScrollToBottom()
+		}
+	})
+	viewer.followCheck.SetChecked(true)
+	viewer.levelSelect = widget.NewSelect(logLevelLabels, func(label string) {
+		for level, levelLabel := range logLevelLabels {
+			if label == levelLabel {
+				viewer.setFilter(func() { viewer.minLevel = logLevel(level) })
+			}
+		}
+	})
+	viewer.levelSelect.SetSelected(logLevelLabels[levelInfo])
+	viewer.fileEntry = widget.NewEntry()
+	viewer.fileEntry.SetPlaceHolder("File name")
+	viewer.fileEntry.OnChanged = func(file string) {
+		viewer.setFilter(func() { viewer.file = strings.ToLower(strings.TrimSpace(file)) })
+	}
+
+	copyButton := widget.NewButtonWithIcon("Copy", theme.ContentCopyIcon(), viewer.copySelection)
+	saveButton := widget.NewButtonWithIcon("Save...", theme.DocumentSaveIcon(), viewer.saveSelection)
+	openButton := widget.NewButtonWithIcon("Open log file", theme.FileIcon(), func() {
+		openLog()
+	})
+	hint := widget.NewLabel("Click an entry to select it, shift-click to select up to another.")
+	hint.TextStyle = fyne.TextStyle{Italic: true}
+
+	viewer.window.Canvas().AddShortcut(&fyne.ShortcutCopy{}, func(fyne.Shortcut) {
+		viewer.copySelection()
+	})
+	viewer.window.Canvas().AddShortcut(&fyne.ShortcutSelectAll{}, func(fyne.Shortcut) {
+		viewer.selectAll()
+	})
+
+	viewer.window.SetContent(container.NewBorder(
+		container.NewBorder(nil, nil,
+			container.NewHBox(widget.NewLabel("Level:"), viewer.levelSelect),
+			viewer.followCheck,
+			viewer.fileEntry,
+		),
+		container.NewBorder(nil, nil, viewer.countLabel, container.NewHBox(copyButton, saveButton, openButton), hint),
+		nil, nil,
+		viewer.list,
+	))
+	viewer.window.Resize(fyne.NewSize(900, 500))
+	viewer.window.SetOnClosed(func() {
+		close(viewer.stop)
+		appState.lock.Lock()
+		appState.logViewer = nil
+		appState.lock.Unlock()
+	})
+
+	viewer.update()
+	go viewer.tail()
+	return viewer
+}
+
+// tail shows new entries as they're logged, until the window is closed.
+func (viewer *logViewer) tail() {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-viewer.stop:
+			return
+		case <-ticker.C:
+			viewer.update()
+		}
+	}
+}
+
+// setFilter changes the filters and shows the entries that match them.
+func (viewer *logViewer) setFilter(change func()) {
+	viewer.lock.Lock()
+	change()
+	viewer.seen = -1
+	viewer.lock.Unlock()
+	viewer.update()
+}
+
+func (viewer *logViewer) matches(entry logEntry) bool {
+	if entry.Level < viewer.minLevel {
+		return false
+	}
+	return viewer.file == "" || strings.Contains(strings.ToLower(entry.field("file")), viewer.file)
+}
+
+// update filters the entries again if there are new ones, and redraws the list.
+func (viewer *logViewer) update() {
+	entries, total := sessionLog.snapshot()
+	viewer.lock.Lock()
+	if total == viewer.seen {
+		viewer.lock.Unlock()
+		return
+	}
+	viewer.seen = total
+	viewer.shown = viewer.shown[:0]
+	for _, entry := range entries {
+		if viewer.matches(entry) {
+			viewer.shown = append(viewer.shown, entry)
+		}
+	}
+	count := fmt.Sprintf("%d entries", len(viewer.shown))
+	viewer.lock.Unlock()
+
+	viewer.countLabel.SetText(count)
+	viewer.list.Refresh()
+	if viewer.followCheck.Checked {
+		viewer.list.ScrollToBottom()
+	}
+}
+
+// jumpToLatest selects and scrolls to the latest error shown, or the latest entry if none is an error.
+func (viewer *logViewer) jumpToLatest() {
+	viewer.lock.Lock()
+	row := len(viewer.shown) - 1
+	for i := len(viewer.shown) - 1; i >= 0; i-- {
+		if viewer.shown[i].Level == levelError {
+			row = i
+			break
+		}
+	}
+	if row < 0 {
+		viewer.lock.Unlock()
+		return
+	}
+	viewer.anchor = viewer.shown[row].Seq
+	viewer.cursor = viewer.anchor
+	viewer.lock.Unlock()
+
+	viewer.list.Refresh()
+	viewer.list.ScrollTo(row)
+}
+
+func (viewer *logViewer) isSelected(seq int) bool {
+	if viewer.anchor < 0 {
+		return false
+	}
+	from, to := viewer.anchor, viewer.cursor
+	if from > to {
+		from, to = to, from
+	}
+	return seq >= from && seq <= to
+}
+
+// selectEntry selects an entry, or extends the selection up to it.
+func (viewer *logViewer) selectEntry(seq int, extend bool) {
+	viewer.lock.Lock()
+	if !extend || viewer.anchor < 0 {
+		viewer.anchor = seq
+	}
+	viewer.cursor = seq
+	viewer.lock.Unlock()
+	viewer.list.Refresh()
+}
+
+func (viewer *logViewer) selectAll() {
+	viewer.lock.Lock()
+	if len(viewer.shown) > 0 {
+		viewer.anchor = viewer.shown[0].Seq
+		viewer.cursor = viewer.shown[len(viewer.shown)-1].Seq
+	}
+	viewer.lock.Unlock()
+	viewer.list.Refresh()
+}
+
+// selection returns the selected entries that are shown, or all of the shown entries if none is selected.
+func (viewer *logViewer) selection() []logEntry {
+	viewer.lock.Lock()
+	defer viewer.lock.Unlock()
+	var selected []logEntry
+	for _, entry := range viewer.shown {
+		if viewer.isSelected(entry.Seq) {
+			selected = append(selected, entry)
+		}
+	}
+	if len(selected) == 0 {
+		return append(selected, viewer.shown...)
+	}
+	return selected
+}
+
+func (viewer *logViewer) copySelection() {
+	var lines []string
+	for _, entry := range viewer.selection() {
+		lines = append(lines, entry.text())
+	}
+	viewer.window.Clipboard().SetContent(strings.Join(lines, "\n"))
+}
+
+// saveSelection saves the selected entries to a file, as JSON lines if its name ends with .json and as text otherwise.
+func (viewer *logViewer) saveSelection() {
+	entries := viewer.selection()
+	go func() {
+		path, err := zenity.SelectFileSave(
+			zenity.Title("Save log entries"),
+			zenity.Filename("log.txt"),
+			zenity.ConfirmOverwrite(),
+			zenity.FileFilters{
+				{Name: "Text files", Patterns: []string{"*.txt"}, CaseFold: false},
+				{Name: "JSON files", Patterns: []string{"*.json"}, CaseFold: false},
+			},
+		)
+		if err != nil {
+			if err != zenity.ErrCanceled {
+				logger.Errorf("Error selecting file: %v", err)
+			}
+			return
+		}
+		asJSON := strings.EqualFold(filepath.Ext(path), ".json")
+		var content strings.Builder
+		for _, entry := range entries {
+			if asJSON {
+				content.WriteString(entry.json())
+			} else {
+				content.WriteString(entry.text())
+			}
+			content.WriteString("\n")
+		}
+		if err := os.WriteFile(path, []byte(content.String()), 0666); err != nil {
+			logger.Errorf("Failed to save log entries: %v\n", err)
+			dialog.ShowError(err, viewer.window)
+		}
+	}()
+}
+
+// logRow is a row of the log viewer. It handles clicks itself rather than through the list's selection, which can
+// neither select a range nor tell whether shift was held.
+type logRow struct {
+	widget.BaseWidget
+	background *canvas.Rectangle
+	text       *canvas.Text
+
+	viewer *logViewer
+	seq    int
+}
+
+func newLogRow(viewer *logViewer) *logRow {
+	row := &logRow{
+		background: canvas.NewRectangle(theme.SelectionColor()),
+		text:       canvas.NewText("", theme.ForegroundColor()),
+		viewer:     viewer,
+	}
+	row.text.TextStyle = fyne.TextStyle{Monospace: true}
+	row.ExtendBaseWidget(row)
+	return row
+}
+
+func (row *logRow) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewMax(
+		row.background,
+		row.text,
+	))
+}
+
+func (row *logRow) setEntry(entry logEntry, selected bool) {
+	row.seq = entry.Seq
+	row.text.Text = entry.text()
+	switch entry.Level {
+	case levelError:
+		row.text.Color = theme.ErrorColor()
+	case levelWarn:
+		row.text.Color = theme.WarningColor()
+	case levelDebug:
+		row.text.Color = theme.DisabledColor()
+	default:
+		row.text.Color = theme.ForegroundColor()
+	}
+	row.background.Hidden = !selected
+	row.Refresh()
+}
+
+// Tapped is handled in MouseDown, but implementing it keeps the list from selecting the row itself.
+func (row *logRow) Tapped(*fyne.PointEvent) {}
+
+func (row *logRow) MouseDown(e *desktop.MouseEvent) {
+	if e.Button == desktop.MouseButtonPrimary {
+		row.viewer.selectEntry(row.seq, e.Modifier&fyne.KeyModifierShift != 0)
+	}
+}
+
+func (row *logRow) MouseUp(*desktop.MouseEvent) {}
@@ -2,19 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"image/color"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
-	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -28,28 +26,15 @@ import (
 	"fyne.io/fyne/v2/widget"
 
 	"github.com/dustin/go-humanize"
-	"github.com/mxk/go-flowrate/flowrate"
 	"github.com/ncruces/zenity"
 	"github.com/skratchdot/open-golang/open"
 )
 
 var (
-	logger      = log.New(os.Stdout, "", log.LstdFlags)
+	logger      = newAppLogger(os.Stdout, logFormatText, levelInfo)
 	logFilePath string
 )
 
-type UserData struct {
-	Video struct {
-		Videos struct {
-			VideoList []struct {
-				Date  string `json:"Date"`
-				Link  string `json:"Link"`
-				Likes string `json:"Likes"`
-			} `json:"VideoList"`
-		} `json:"Videos"`
-	} `json:"Video"`
-}
-
 func downloadFile(ctx context.Context, url, filepath string, wc *WriteCounter) error {
 	// Create a request with context
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
@@ -58,11 +43,14 @@ func downloadFile(ctx context.Context, url, filepath string, wc *WriteCounter) e
 	}
 
 	// Get the data
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doRequest(req, requestDownload)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
 
 	// Create the temporary file
 	tempFilePath := filepath + ".temp"
@@ -78,6 +66,7 @@ func downloadFile(ctx context.Context, url, filepath string, wc *WriteCounter) e
 		return err
 	}
 	wc.ContentLength = contentLength
+	wc.Progress.contentLength.Store(contentLength)
 
 	// Write the body to the temporary file with context cancellation check
 	buf := make([]byte, 4096)
@@ -101,8 +90,18 @@ func downloadFile(ctx context.Context, url, filepath string, wc *WriteCounter) e
 		wc.Write(buf[:n])
 	}
 
-	// Rename the temporary file to the real file
+	// Make sure the whole video arrived before putting it in place
 	out.Close()
+	if wc.Total != contentLength {
+		_ = os.Remove(tempFilePath)
+		return fmt.Errorf("downloaded %d bytes, expected %d", wc.Total, contentLength)
+	}
+	if err := verifyMP4(tempFilePath, contentLength); err != nil {
+		_ = os.Remove(tempFilePath)
+		return fmt.Errorf("downloaded file is corrupt: %v", err)
+	}
+
+	// Rename the temporary file to the real file
 	err = os.Rename(tempFilePath, filepath)
 	if err != nil {
 		return err
@@ -111,25 +110,48 @@ func downloadFile(ctx context.Context, url, filepath string, wc *WriteCounter) e
 	return nil
 }
 
+// fetchContentLength returns the size of the file at url, according to a HEAD request.
+func fetchContentLength(ctx context.Context, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := doRequest(req, requestSize)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+}
+
 type WriteCounter struct {
 	Total         int64
 	ContentLength int64
-	ProgressState binding.Float
-	Monitor       *flowrate.Monitor
+	Progress      *itemProgress
+	Hash          hash.Hash
 }
 
 func (wc *WriteCounter) Write(p []byte) (int, error) {
 	n := len(p)
 	wc.Total += int64(n)
-	floatValue := float64(wc.Total) / float64(wc.ContentLength)
-	wc.ProgressState.Set(floatValue)
-	wc.Monitor.Update(n)
+	if wc.Hash != nil {
+		wc.Hash.Write(p)
+	}
+	wc.Progress.addBytes(int64(n))
+	metrics.bytes.Add(int64(n))
 	return n, nil
 }
 
 type VideoLink struct {
-	Date string
-	Link string
+	Date    string
+	Link    string
+	Account string // Label of the account the export is from, if any.
+	// Metadata holds the other fields of the post in the export, e.g. its caption, sound, location or likes, by
+	// their name in the export.
+	Metadata map[string]string
 }
 
 func sortLinksByDateDescending(links []VideoLink) {
@@ -138,61 +160,51 @@ func sortLinksByDateDescending(links []VideoLink) {
 	})
 }
 
-func readAndParseFile(filePath string, fileType string) ([]VideoLink, error) {
-	logger.Printf("Reading file %s as %s", filePath, fileType)
-	fileContent, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to read file: %v", err)
-	}
-
-	var links []VideoLink
-
+// readAndParseFile reads the posts of an export, newest first. The parsers stream the file, but its posts are all
+// kept: they're sorted by date and merged with other exports before anything is downloaded. That only costs a few
+// hundred bytes per post (see BenchmarkReadAndParseFile), unlike the rest of user_data.json, which is skipped.
+func readAndParseFile(filePath string, fileType string) ([]VideoLink, *parseReport, error) {
+	logger.Debugf("Reading file %s as %s", filePath, fileType)
+	var parse func(io.Reader, func(VideoLink) error, *parseReport) error
 	switch fileType {
 	case "Posts.txt":
-		lines := strings.Split(string(fileContent), "\n")
-		for i := 0; i < len(lines); i++ {
-			line := lines[i]
-			if strings.HasPrefix(line, "Date:") {
-				date := strings.TrimSpace(strings.TrimPrefix(line, "Date:"))
-				i++
-				if i < len(lines) && strings.HasPrefix(lines[i], "Link:") {
-					link := strings.TrimSpace(strings.TrimPrefix(lines[i], "Link:"))
-					links = append(links, VideoLink{Date: date, Link: link})
-				}
-			}
-		}
+		parse = parsePosts
 	case "user_data.json":
-		var userData UserData
-		err := json.Unmarshal(fileContent, &userData)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse JSON file: %v", err)
-		}
+		parse = parseUserData
+	default:
+		return nil, nil, fmt.Errorf("You must select a file type.")
+	}
 
-		for _, video := range userData.Video.Videos.VideoList {
-			links = append(links, VideoLink{Date: video.Date, Link: video.Link})
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read file: %v", err)
+	}
+	defer file.Close()
+
+	var links []VideoLink
+	report := &parseReport{}
+	err = parse(file, func(link VideoLink) error {
+		links = append(links, link)
+		return nil
+	}, report)
+	if err != nil {
+		if fileType == "user_data.json" {
+			return nil, report, fmt.Errorf("failed to parse JSON file: %v", err)
 		}
-	default:
-		return nil, fmt.Errorf("You must select a file type.")
+		return nil, report, fmt.Errorf("Failed to read file: %v", err)
+	}
+	for _, warning := range report.Warnings {
+		logger.Warnf("%s, %s\n", filePath, warning)
 	}
 
 	if len(links) == 0 {
-		return nil, fmt.Errorf("No links found in the file. Is the file type correct?")
+		return nil, report, fmt.Errorf("No links found in the file. Is the file type correct?")
 	}
+	logger.Infof("Read %d posts from %s (%s), with %d warnings\n", len(links), filePath, report.Schema, len(report.Warnings))
 
 	sortLinksByDateDescending(links)
 
-	return links, nil
-}
-
-type download struct {
-	name     binding.String
-	progress binding.Float
-	status   binding.String // "queued", "in progress", "succeeded", or "failed"
-}
-
-type downloadState struct {
-	data   []download
-	widget *widget.List
+	return links, report, nil
 }
 
 type appState struct {
@@ -200,8 +212,18 @@ type appState struct {
 	inputFile    binding.String
 	outputDir    binding.String
 	fileType     binding.String
+	inputAccount binding.String
+	moreInputs   binding.String // JSON list of additional exportInputs.
 	skipExisting binding.Bool
+	skipMode     binding.String
+	onlyNew      binding.Bool
+	fileNames    binding.String // File name template, see expandFileNameTemplate.
+	sidecars     binding.Bool
+	gallery      binding.Bool
 	parallelism  binding.Float
+	order        binding.String
+	// Columns last picked to export the catalog with, separated by commas.
+	exportColumns binding.String
 
 	completed      binding.Int
 	errors         binding.Int
@@ -209,38 +231,65 @@ type appState struct {
 	total          binding.Int
 	globalProgress binding.Float
 	bytesPerSecond binding.Int
-	monitor        *flowrate.Monitor
+	eta            binding.Int // Estimated seconds left, or 0 if unknown.
+	progress       *progressHub
 
 	// Mutable state, to work around the limitations of Fyne's data binding.
 	downloads *downloadState
 
 	isDownloading binding.Bool
-	cancelHook    *atomic.Value
-	// Lock for the state transition between "not downloading" and "downloading". When this is locked, `cancelHook`
-	// and `isDownloading` are being updated at the same time.
+	// The most recent download job, which keeps accepting single items (e.g. retries) after it finished.
+	job *downloadJob
+	// The server of the archive, while it's being served.
+	server *archiveServer
+	// The log viewer, while it's open.
+	logViewer *logViewer
+	// Past runs, saved in the app's data folder.
+	history *runHistory
+	// Lock for the state transition between "not downloading" and "downloading". When this is locked, `job`
+	// and `isDownloading` are being updated at the same time. It also guards `server` and `logViewer`.
 	lock sync.Mutex
 }
 
 func main() {
+	if isCommand(os.Args[1:]) {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	a := app.NewWithID("com.aengelberg.tiktok-archiver")
 	w := a.NewWindow("TikTok Archiver")
 
-	newLogger, err := createLogger()
+	newLogger, err := createLogger(os.Stdout)
 	if err != nil {
-		logger.Printf("Failed to create logger: %v\n", err)
+		logger.Errorf("Failed to create logger: %v\n", err)
 	} else {
 		logger = newLogger
 	}
+	logger.SetBuffer(sessionLog)
 
-	logger.Printf("Starting TikTok Archiver\n")
+	logger.Infof("Starting TikTok Archiver\n")
 
-	appState := appState{
+	// Skipping existing videos and the gallery are on by default.
+	a.Preferences().SetBool("skipExisting", a.Preferences().BoolWithFallback("skipExisting", true))
+	a.Preferences().SetBool("buildGallery", a.Preferences().BoolWithFallback("buildGallery", true))
+
+	appState := &appState{
 		window:       w,
 		inputFile:    binding.BindPreferenceString("inputFile", a.Preferences()),
 		outputDir:    binding.BindPreferenceString("outputDir", a.Preferences()),
 		fileType:     binding.BindPreferenceString("fileType", a.Preferences()),
-		skipExisting: binding.NewBool(),
+		inputAccount: binding.BindPreferenceString("inputAccount", a.Preferences()),
+		moreInputs:   binding.BindPreferenceString("moreInputs", a.Preferences()),
+		skipExisting: binding.BindPreferenceBool("skipExisting", a.Preferences()),
+		skipMode:     binding.BindPreferenceString("skipMode", a.Preferences()),
+		onlyNew:      binding.BindPreferenceBool("onlyNew", a.Preferences()),
+		fileNames:    binding.BindPreferenceString("fileNameTemplate", a.Preferences()),
+		sidecars:     binding.BindPreferenceBool("writeSidecars", a.Preferences()),
+		gallery:      binding.BindPreferenceBool("buildGallery", a.Preferences()),
 		parallelism:  binding.BindPreferenceFloat("parallelism", a.Preferences()),
+		order:        binding.BindPreferenceString("downloadOrder", a.Preferences()),
+
+		exportColumns: binding.BindPreferenceString("exportColumns", a.Preferences()),
 
 		completed:      binding.NewInt(),
 		errors:         binding.NewInt(),
@@ -248,57 +297,78 @@ func main() {
 		total:          binding.NewInt(),
 		globalProgress: binding.NewFloat(),
 		bytesPerSecond: binding.NewInt(),
-		monitor:        flowrate.New(100*time.Millisecond, 1*time.Second),
-		downloads: &downloadState{
-			data:   []download{},
-			widget: nil,
-		},
+		eta:            binding.NewInt(),
+		progress:       newProgressHub(),
+		downloads:      newDownloadState(),
 
 		isDownloading: binding.NewBool(),
-		cancelHook:    &atomic.Value{},
 		lock:          sync.Mutex{},
 	}
 
-	startMonitor(appState)
+	if dataDir, err := appDataDir(); err != nil {
+		logger.Errorf("Failed to find the run history: %v\n", err)
+		appState.history = &runHistory{path: historyFileName}
+	} else if appState.history, err = loadRunHistory(filepath.Join(dataDir, historyFileName)); err != nil {
+		logger.Errorf("Failed to load the run history: %v\n", err)
+	}
+
+	appState.progress.subscribe(100*time.Millisecond, appState.updateProgress)
+	appState.progress.subscribe(10*time.Second, logProgress)
 
 	createUI(appState)
 
 	w.ShowAndRun()
 }
 
-func createLogger() (*log.Logger, error) {
-	// Generate the filename for the log file.
+// appDataDir returns the folder the app keeps its own files in, like its logs.
+func appDataDir() (string, error) {
 	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "TikTok Archiver"), nil
+}
+
+// createLogger returns a logger that writes to a new log file, and to console. Old log files are deleted.
+func createLogger(console io.Writer) (*appLogger, error) {
+	// Generate the filename for the log file.
+	dataDir, err := appDataDir()
 	if err != nil {
 		return nil, err
 	}
-	path := filepath.Join(configDir, "TikTok Archiver", "log", fmt.Sprintf("log-%s.txt", time.Now().Format("2006-01-02-15-04-05")))
-	logger.Printf("Logging to %s", path)
+	extension := ".txt"
+	if logFormat == logFormatJSON {
+		extension = ".json"
+	}
+	path := filepath.Join(dataDir, "log", fmt.Sprintf("log-%s%s", time.Now().Format("2006-01-02-15-04-05"), extension))
+	logger.Infof("Logging to %s", path)
 	err = os.MkdirAll(filepath.Dir(path), 0777)
 	if err != nil {
 		return nil, err
 	}
+	pruneLogs(filepath.Dir(path))
 	// Open the log file for writing. Create it if it doesn't exist.
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		return nil, err
 	}
 	logFilePath = path
-	newLogger := log.New(io.MultiWriter(file, os.Stdout), "", log.Ldate|log.Ltime)
-	return newLogger, nil
+	return newAppLogger(io.MultiWriter(file, console), logFormat, minLogLevel), nil
 }
 
-func startMonitor(appState appState) {
-	go func() {
-		for {
-			time.Sleep(1 * time.Second)
-			bytesPerSecond := appState.monitor.Status().InstRate
-			appState.bytesPerSecond.Set(int(bytesPerSecond))
-		}
-	}()
+// updateProgress is a progress subscriber that pushes a snapshot into the UI bindings.
+func (appState *appState) updateProgress(snapshot progressSnapshot) {
+	appState.downloads.apply(snapshot)
+	appState.total.Set(snapshot.Total)
+	appState.completed.Set(snapshot.Completed)
+	appState.errors.Set(snapshot.Errors)
+	appState.skipped.Set(snapshot.Skipped)
+	appState.bytesPerSecond.Set(int(snapshot.BytesPerSecond))
+	appState.eta.Set(int(snapshot.ETA.Seconds()))
+	appState.globalProgress.Set(snapshot.Fraction)
 }
 
-func createUI(appState appState) {
+func createUI(appState *appState) {
 	// User inputs
 	inputIcon := widget.NewIcon(theme.FileIcon())
 	inputFilename := widget.NewLabel("No file selected")
@@ -316,6 +386,23 @@ func createUI(appState appState) {
 		selectInputFile(appState)
 	})
 
+	accountEntry := widget.NewEntryWithData(appState.inputAccount)
+	accountEntry.SetPlaceHolder("Optional")
+	moreInputsLabel := widget.NewLabel("")
+	appState.moreInputs.AddListener(binding.NewDataListener(func() {
+		switch count := len(appState.extraInputs()); count {
+		case 0:
+			moreInputsLabel.SetText("None")
+		case 1:
+			moreInputsLabel.SetText("1 more export")
+		default:
+			moreInputsLabel.SetText(fmt.Sprintf("%d more exports", count))
+		}
+	}))
+	moreInputsButton := widget.NewButton("Edit...", func() {
+		showExportsDialog(appState)
+	})
+
 	outputIcon := widget.NewIcon(theme.FolderIcon())
 	outputDir := widget.NewLabel("No folder selected")
 	appState.outputDir.AddListener(binding.NewDataListener(func() {
@@ -337,6 +424,26 @@ func createUI(appState appState) {
 		appState.fileType.Set(fileType)
 	})
 	fileTypeSelect.SetSelected(initialFileType)
+	// Settings can also change from elsewhere, e.g. when running a past run again.
+	appState.fileType.AddListener(binding.NewDataListener(func() {
+		if fileType, _ := appState.fileType.Get(); fileType != fileTypeSelect.Selected {
+			fileTypeSelect.SetSelected(fileType)
+		}
+	}))
+
+	initialOrder, _ := appState.order.Get()
+	if initialOrder == "" {
+		initialOrder = orderNewestFirst
+	}
+	orderSelect := widget.NewSelect(downloadOrders, func(order string) {
+		appState.order.Set(order)
+	})
+	orderSelect.SetSelected(initialOrder)
+	appState.order.AddListener(binding.NewDataListener(func() {
+		if order, _ := appState.order.Get(); order != orderSelect.Selected {
+			orderSelect.SetSelected(order)
+		}
+	}))
 
 	parallelismSlider := widget.NewSliderWithData(1, 16, appState.parallelism)
 	if initialParallelism, _ := appState.parallelism.Get(); initialParallelism == 0 {
@@ -354,8 +461,8 @@ func createUI(appState appState) {
 	})
 	cancelButton.SetIcon(theme.CancelIcon())
 
-	logButton := widget.NewButton("Open Log", func() {
-		openLog()
+	logButton := widget.NewButton("Show Log", func() {
+		showLogViewer(appState, "")
 	})
 	logButton.SetIcon(theme.DocumentIcon())
 
@@ -372,7 +479,39 @@ func createUI(appState appState) {
 
 	// Advanced options
 	skipExistingCheckbox := widget.NewCheckWithData("Skip already-downloaded videos", appState.skipExisting)
-	appState.skipExisting.Set(true)
+	initialSkipMode, _ := appState.skipMode.Get()
+	if initialSkipMode == "" {
+		initialSkipMode = skipIfExists
+	}
+	skipModeSelect := widget.NewSelect(skipModes, func(mode string) {
+		appState.skipMode.Set(mode)
+	})
+	skipModeSelect.SetSelected(initialSkipMode)
+	appState.skipMode.AddListener(binding.NewDataListener(func() {
+		if skipMode, _ := appState.skipMode.Get(); skipMode != skipModeSelect.Selected {
+			skipModeSelect.SetSelected(skipMode)
+		}
+	}))
+	onlyNewCheckbox := widget.NewCheckWithData("Only download posts that are new since the last export", appState.onlyNew)
+	galleryCheckbox := widget.NewCheckWithData("Update the HTML gallery after downloading", appState.gallery)
+	sidecarsCheckbox := widget.NewCheckWithData("Save each post's caption and details next to its video", appState.sidecars)
+	fileNamesEntry := widget.NewEntryWithData(appState.fileNames)
+	fileNamesEntry.SetPlaceHolder(defaultFileNameTemplate)
+	fileNamesEntry.Validator = func(template string) error {
+		if template == "" {
+			return nil
+		}
+		return validateFileNameTemplate(template)
+	}
+	fileNamesHint := widget.NewLabel("e.g. {date} {caption}. Any field of the export can be used.")
+	fileNamesHint.TextStyle = fyne.TextStyle{Italic: true}
+	appState.skipExisting.AddListener(binding.NewDataListener(func() {
+		if skipExisting, _ := appState.skipExisting.Get(); skipExisting {
+			skipModeSelect.Enable()
+		} else {
+			skipModeSelect.Disable()
+		}
+	}))
 
 	leftSide := container.NewBorder(
 		nil, container.NewVBox(
@@ -384,12 +523,21 @@ func createUI(appState appState) {
 			container.New(layout.NewFormLayout(),
 				widget.NewLabel("Read from:"), container.NewHBox(inputIcon, inputFilename, layout.NewSpacer(), inputButton),
 				widget.NewLabel("File type:"), fileTypeSelect,
+				widget.NewLabel("Account:"), accountEntry,
+				widget.NewLabel("Also read:"), container.NewHBox(moreInputsLabel, layout.NewSpacer(), moreInputsButton),
 				widget.NewLabel("Download to:"), container.NewHBox(outputIcon, outputDir, layout.NewSpacer(), outputButton),
 			),
 			widget.NewAccordion(
 				widget.NewAccordionItem("Advanced Options",
 					container.NewVBox(
 						skipExistingCheckbox,
+						container.NewBorder(nil, nil, widget.NewLabel("Downloaded if:"), nil, skipModeSelect),
+						onlyNewCheckbox,
+						container.NewBorder(nil, nil, widget.NewLabel("File names:"), nil, fileNamesEntry),
+						fileNamesHint,
+						sidecarsCheckbox,
+						galleryCheckbox,
+						container.NewBorder(nil, nil, widget.NewLabel("Download order:"), nil, orderSelect),
 						container.NewBorder(nil, nil, widget.NewLabel("Parallelism:"), nil,
 							container.NewBorder(
 								nil, nil, widget.NewLabel("1"), widget.NewLabel("16"),
@@ -417,10 +565,16 @@ func createUI(appState appState) {
 	appState.total.AddListener(updateCounter)
 
 	dataSpeed := widget.NewLabel("")
-	appState.bytesPerSecond.AddListener(binding.NewDataListener(func() {
+	updateSpeed := binding.NewDataListener(func() {
 		bps, _ := appState.bytesPerSecond.Get()
-		dataSpeed.SetText(fmt.Sprintf("Downloading %s/s", humanize.Bytes(uint64(bps))))
-	}))
+		text := fmt.Sprintf("Downloading %s/s", humanize.Bytes(uint64(bps)))
+		if eta, _ := appState.eta.Get(); eta > 0 {
+			text += fmt.Sprintf(", about %s left", time.Duration(eta)*time.Second)
+		}
+		dataSpeed.SetText(text)
+	})
+	appState.bytesPerSecond.AddListener(updateSpeed)
+	appState.eta.AddListener(updateSpeed)
 
 	errorTracker := canvas.NewText("", color.RGBA{R: 255, A: 255})
 	appState.errors.AddListener(binding.NewDataListener(func() {
@@ -449,6 +603,11 @@ func createUI(appState appState) {
 	downloadList := newDownloadListWidget(appState)
 	appState.downloads.widget = downloadList
 	scrollContainer := container.NewVScroll(downloadList)
+	detailsPane := newDownloadDetailsPane(appState)
+	appState.downloads.detailsPane = detailsPane
+	toolbar := newDownloadToolbar(appState)
+	appState.downloads.toolbar = toolbar
+	registerItemShortcuts(appState)
 
 	rightSide := container.NewBorder(
 		container.NewVBox(
@@ -460,85 +619,77 @@ func createUI(appState appState) {
 				skipTracker,
 			),
 		),
-		nil, nil, nil,
-		scrollContainer,
+		detailsPane.container, nil, nil,
+		container.NewBorder(toolbar.container, nil, nil, nil, scrollContainer),
 	)
 
 	content := container.NewHSplit(leftSide, rightSide)
 	content.SetOffset(0.4)
 
+	appState.window.SetMainMenu(fyne.NewMainMenu(
+		fyne.NewMenu("Archive",
+			fyne.NewMenuItem("Verify archive", func() {
+				verifyArchive(appState)
+			}),
+			fyne.NewMenuItem("Audit archive", func() {
+				showAudit(appState)
+			}),
+			fyne.NewMenuItem("Open gallery", func() {
+				openGallery(appState)
+			}),
+			fyne.NewMenuItem("Export catalog...", func() {
+				showCatalogExport(appState)
+			}),
+			fyne.NewMenuItem("Serve archive...", func() {
+				showServeDialog(appState)
+			}),
+			fyne.NewMenuItem("Run history", func() {
+				showHistory(appState)
+			}),
+		),
+		fyne.NewMenu("Help",
+			fyne.NewMenuItem("Create diagnostics bundle...", func() {
+				createDiagnostics(appState)
+			}),
+		),
+	))
 	appState.window.SetContent(content)
 	appState.window.Resize(fyne.NewSize(800, 500))
 }
 
-func newDownloadListWidget(appState appState) *widget.List {
-	return widget.NewList(
-		func() int {
-			return len(appState.downloads.data)
-		},
-		func() fyne.CanvasObject {
-			statusIcon := widget.NewIcon(getStatusIcon("queued"))
-			fileNameLabel := widget.NewLabel("")
-			progressBar := widget.NewProgressBar()
-			return container.New(layout.NewFormLayout(),
-				statusIcon,
-				container.New(layout.NewFormLayout(),
-					fileNameLabel, progressBar,
-				),
-			)
-		},
-		func(id widget.ListItemID, obj fyne.CanvasObject) {
-			hbox := obj.(*fyne.Container)
-			statusIcon := hbox.Objects[0].(*widget.Icon)
-			fileNameLabel := hbox.Objects[1].(*fyne.Container).Objects[0].(*widget.Label)
-			progressBar := hbox.Objects[1].(*fyne.Container).Objects[1].(*widget.ProgressBar)
-
-			download := appState.downloads.data[id]
-
-			download.status.AddListener(binding.NewDataListener(func() {
-				status, _ := download.status.Get()
-				statusIcon.SetResource(getStatusIcon(status))
-
-			}))
-			fileNameLabel.Bind(download.name)
-			progressBar.Bind(download.progress)
-		},
-	)
-}
-
-func selectInputFile(appState appState) {
+func selectInputFile(appState *appState) {
 	path, err := zenity.SelectFile(
 		zenity.Title("Select TikTok video archive file"),
 		zenity.FileFilters{
-			{"JSON files", []string{"*.json"}, false},
-			{"Text files", []string{"*.txt"}, false},
+			{Name: "JSON files", Patterns: []string{"*.json"}, CaseFold: false},
+			{Name: "Text files", Patterns: []string{"*.txt"}, CaseFold: false},
 		},
 	)
 	if err != nil {
 		if err != zenity.ErrCanceled {
-			logger.Printf("Error selecting file: %v", err)
+			logger.Errorf("Error selecting file: %v", err)
 		}
 		return
 	}
 	if filepath.Base(path) == "Posts.txt" {
-		logger.Printf("Automatically setting file type to Posts.txt")
+		logger.Debugf("Automatically setting file type to Posts.txt")
 		appState.fileType.Set("Posts.txt")
 	}
 	if filepath.Base(path) == "user_data.json" {
-		logger.Printf("Automatically setting file type to user_data.json")
+		logger.Debugf("Automatically setting file type to user_data.json")
 		appState.fileType.Set("user_data.json")
 	}
 	appState.inputFile.Set(path)
 }
 
-func selectOutputDir(appState appState) {
+func selectOutputDir(appState *appState) {
 	dir, err := zenity.SelectFile(
 		zenity.Title("Select folder for downloaded videos"),
 		zenity.Directory(),
 	)
 	if err != nil {
 		if err != zenity.ErrCanceled {
-			logger.Printf("Error selecting directory: %v", err)
+			logger.Errorf("Error selecting directory: %v", err)
 		}
 		return
 	}
@@ -547,174 +698,178 @@ func selectOutputDir(appState appState) {
 
 func getStatusIcon(status string) fyne.Resource {
 	switch status {
-	case "queued":
+	case statusQueued:
 		return theme.FileVideoIcon()
-	case "in progress":
+	case statusInProgress:
 		return theme.DownloadIcon()
-	case "succeeded":
+	case statusSucceeded:
 		return theme.ConfirmIcon()
-	case "failed":
+	case statusFailed:
 		return theme.ErrorIcon()
-	case "cancelled":
+	case statusSkipped:
+		return theme.MediaSkipNextIcon()
+	case statusCancelled:
 		return theme.CancelIcon()
 	}
 	return nil
 }
 
-// Quick and dirty serialization for incrementing counters
-var incLock sync.Mutex
+// videoFileName returns the name of the file a video is saved to.
+func videoFileName(link VideoLink, template string) string {
+	if template == "" {
+		template = defaultFileNameTemplate
+	}
+	return expandFileNameTemplate(template, link) + ".mp4"
+}
+
+// jobOptions returns the download options currently selected in the UI.
+func (appState *appState) jobOptions() jobOptions {
+	skipExisting, _ := appState.skipExisting.Get()
+	skipMode, _ := appState.skipMode.Get()
+	parallelism, _ := appState.parallelism.Get()
+	order, _ := appState.order.Get()
+	sidecars, _ := appState.sidecars.Get()
+	fileNames, _ := appState.fileNames.Get()
+	return jobOptions{
+		skipExisting:  skipExisting,
+		skipMode:      skipMode,
+		parallelism:   int(parallelism),
+		order:         order,
+		writeSidecars: sidecars,
+		fileNames:     fileNameTemplateOrDefault(fileNames),
+	}
+}
 
-func inc(intState binding.Int) {
-	incLock.Lock()
-	defer incLock.Unlock()
-	val, _ := intState.Get()
-	intState.Set(val + 1)
+// downloadFiles downloads every video of the input file and the additional exports.
+func downloadFiles(appState *appState) {
+	startDownloads(appState, func() ([]VideoLink, jobOptions, error) {
+		outputDir, _ := appState.outputDir.Get()
+		onlyNew, _ := appState.onlyNew.Get()
+		options := appState.jobOptions()
+		// Read and parse the input files
+		links, exports, err := readExports(appState.inputs())
+		if err != nil {
+			logger.Errorf("Error reading and parsing file: %v", err)
+			return nil, jobOptions{}, err
+		}
+		if !confirmParseWarnings(appState, exports) {
+			return nil, jobOptions{}, context.Canceled
+		}
+		// Keep track of the exports in the output directory's catalog
+		links, result, err := syncExports(exports, links, outputDir, onlyNew, options.fileNames)
+		if err != nil {
+			logger.Errorf("Error updating the catalog: %v", err)
+			return nil, jobOptions{}, err
+		}
+		if len(result.Deleted) > 0 {
+			dialog.ShowInformation("Deleted posts", fmt.Sprintf(
+				"%d posts from earlier exports aren't in this one anymore, so they were probably deleted or made private. "+
+					"Their videos are kept, and flagged in the catalog.", len(result.Deleted)), appState.window)
+		}
+		return links, options, nil
+	})
 }
 
-func downloadFiles(appState appState) {
+// startDownloads switches to the downloading state and downloads the links returned by prepare to the output
+// directory. prepare runs in the background, since it may take a while.
+func startDownloads(appState *appState, prepare func() ([]VideoLink, jobOptions, error)) {
 	appState.lock.Lock()
 	defer appState.lock.Unlock()
 	if isDownloading, _ := appState.isDownloading.Get(); isDownloading {
 		return
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	appState.cancelHook.Swap(cancel)
 	appState.isDownloading.Set(true)
 	go func() {
-		inputFilePath, _ := appState.inputFile.Get()
-		fileType, _ := appState.fileType.Get()
 		outputDir, _ := appState.outputDir.Get()
-		skipExisting, _ := appState.skipExisting.Get()
-		// Read and parse the input file
-		links, err := readAndParseFile(inputFilePath, fileType)
+		inputs := appState.inputs()
+		runOptions := appState.runOptions()
+		links, options, err := prepare()
+		if errors.Is(err, context.Canceled) {
+			logger.Infof("Downloads cancelled.\n")
+			appState.isDownloading.Set(false)
+			return
+		}
 		if err != nil {
-			logger.Printf("Error reading and parsing file: %v", err)
 			dialog.ShowError(err, appState.window)
 			appState.isDownloading.Set(false)
 			return
 		}
-
-		appState.completed.Set(0)
-		appState.errors.Set(0)
-		appState.skipped.Set(0)
-		appState.total.Set(len(links))
-
-		type downloadableFile struct {
-			download
-			path string
-			name string
-		}
-
-		initialDownloads := make([]download, len(links))
-		downloadableFiles := make([]downloadableFile, len(links))
-
-		for i, link := range links {
-			fileName := fmt.Sprintf("%s.mp4", strings.Replace(strings.Replace(link.Date, " ", "-", -1), ":", "-", -1))
-			filePath := filepath.Join(outputDir, fileName)
-			file := download{
-				name:     binding.NewString(),
-				status:   binding.NewString(),
-				progress: binding.NewFloat(),
-			}
-			file.status.Set("queued")
-			file.name.Set(fileName)
-			initialDownloads[i] = file
-			downloadableFiles[i] = downloadableFile{
-				download: file,
-				name:     fileName,
-				path:     filePath,
-			}
+		if len(links) == 0 {
+			dialog.ShowInformation("Nothing to download", "All of the videos are already downloaded.", appState.window)
+			appState.isDownloading.Set(false)
+			return
 		}
 
-		appState.downloads.data = initialDownloads
-		appState.downloads.widget.Refresh()
-
-		parallelismFloat, _ := appState.parallelism.Get()
-		workerPool := make(chan struct{}, int64(parallelismFloat))
-		downloadWg := sync.WaitGroup{}
-		downloadWg.Add(len(links))
-
-		for i, _ := range links {
-			file := downloadableFiles[i]
-			filePath := file.path
-			fileName := file.name
-
-			workerPool <- struct{}{} // Acquire a worker from the pool
-
-			appState.globalProgress.Set(float64(i) / float64(len(links)))
-
-			select {
-			case <-ctx.Done():
-				logger.Printf("Downloads cancelled.\n")
-				return
-			default:
-			}
-
-			go func(i int) {
-				file := downloadableFiles[i]
-				link := links[i]
-				defer func() { <-workerPool }() // Release the worker back to the pool
-				defer downloadWg.Done()
-
-				if skipExisting {
-					if _, err := os.Stat(filePath); err == nil {
-						logger.Printf("%s already exists. Skipping...\n", fileName)
-						file.status.Set("succeeded")
-						file.progress.Set(1.0)
-						inc(appState.completed)
-						inc(appState.skipped)
-						return
-					}
-				}
-
-				logger.Printf("Downloading %s...\n", fileName)
-				wc := &WriteCounter{
-					ProgressState: file.progress,
-					Monitor:       appState.monitor,
-				}
-				file.status.Set("in progress")
-				err := downloadFile(ctx, link.Link, filePath, wc)
-				if err != nil {
-					if err == context.Canceled {
-						logger.Printf("Download of %s cancelled.\n", fileName)
-						file.status.Set("cancelled")
-						return
-					}
-					logger.Printf("Failed to download %s: %v\n", fileName, err)
-					file.status.Set("failed")
-					inc(appState.completed)
-					inc(appState.errors)
-				} else {
-					logger.Printf("Downloaded %s successfully.\n", fileName)
-					file.status.Set("succeeded")
-					inc(appState.completed)
-				}
-			}(i)
-		}
-		downloadWg.Wait()
-		logger.Printf("All downloads completed.\n")
-		appState.globalProgress.Set(1.0)
 		appState.lock.Lock()
 		defer appState.lock.Unlock()
 		if isDownloading, _ := appState.isDownloading.Get(); !isDownloading {
+			logger.Infof("Downloads cancelled.\n")
 			return
 		}
-		appState.isDownloading.Set(false)
+		// The options given by prepare win, e.g. when verifying an archive downloads videos again.
+		runOptions.SkipExisting = options.skipExisting
+		startedAt := time.Now()
+		appState.job = launchJob(appState.progress, outputDir, links, options, func(job *downloadJob) {
+			logger.Infof("All downloads completed.\n")
+			appState.history.record(newHistoryRun(startedAt, inputs, outputDir, runOptions, job.run, job.cancelled()))
+			if gallery, _ := appState.gallery.Get(); gallery {
+				if err := buildGallery(outputDir); err != nil {
+					logger.Errorf("Failed to update the gallery: %v\n", err)
+				}
+			}
+			appState.lock.Lock()
+			defer appState.lock.Unlock()
+			if appState.job == job {
+				appState.isDownloading.Set(false)
+			}
+		})
 	}()
 }
 
-func cancelDownloads(appState appState) {
+func cancelDownloads(appState *appState) {
 	appState.lock.Lock()
 	defer appState.lock.Unlock()
 	if isDownloading, _ := appState.isDownloading.Get(); !isDownloading {
 		return
 	}
-	if cancel := appState.cancelHook.Load(); cancel != nil {
-		cancel.(context.CancelFunc)()
+	if appState.job != nil {
+		appState.job.cancelAll()
 	}
 	appState.isDownloading.Set(false)
 }
 
+// retryDownload downloads a single item of the most recent job again, restarting the job if it already finished.
+func retryDownload(appState *appState, i int) {
+	appState.lock.Lock()
+	defer appState.lock.Unlock()
+	if appState.job == nil {
+		return
+	}
+	if appState.job.retry(i) {
+		appState.isDownloading.Set(true)
+	}
+}
+
+// prioritizeDownload moves a queued item of the most recent job to the front of its queue.
+func prioritizeDownload(appState *appState, i int) {
+	appState.lock.Lock()
+	defer appState.lock.Unlock()
+	if appState.job == nil {
+		return
+	}
+	appState.job.prioritize(i)
+}
+
+// cancelDownload cancels a single item of the most recent job.
+func cancelDownload(appState *appState, i int) {
+	appState.lock.Lock()
+	defer appState.lock.Unlock()
+	if appState.job == nil {
+		return
+	}
+	appState.job.cancelItem(i)
+}
+
 func openLog() {
 	if logFilePath == "" {
 		return
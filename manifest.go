@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// manifestFileName is the name of the manifest kept in each output directory.
+const manifestFileName = "tiktok-archiver-manifest.json"
+
+// manifest records every video downloaded into an output directory, so that later runs can tell whether a file on
+// disk is really the one that was downloaded.
+type manifest struct {
+	path  string
+	lock  sync.Mutex
+	dirty int // Number of changes since the manifest was last saved.
+
+	Version int                      `json:"version"`
+	Files   map[string]manifestEntry `json:"files"` // By file name.
+}
+
+type manifestEntry struct {
+	Link         string    `json:"link"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+}
+
+// loadManifest reads the manifest of an output directory, returning an empty one if there isn't any yet, or if it
+// can't be read.
+func loadManifest(dir string) (*manifest, error) {
+	newManifest := func() *manifest {
+		return &manifest{
+			path:    filepath.Join(dir, manifestFileName),
+			Version: 1,
+			Files:   map[string]manifestEntry{},
+		}
+	}
+	m := newManifest()
+	content, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(content, m); err != nil {
+		// Don't go on with whatever was decoded before the error.
+		return newManifest(), err
+	}
+	if m.Files == nil {
+		m.Files = map[string]manifestEntry{}
+	}
+	return m, nil
+}
+
+// setAside renames the manifest's file out of the way, so that saving the manifest doesn't overwrite it, and returns
+// its new path. It's for manifests that couldn't be read: their file may still be fixed by hand.
+func (m *manifest) setAside() (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	path := m.path + ".corrupt-" + time.Now().Format("20060102-150405")
+	if err := os.Rename(m.path, path); err != nil {
+		// Don't save anything over the file then.
+		m.path = ""
+		return "", err
+	}
+	return path, nil
+}
+
+func (m *manifest) get(name string) (manifestEntry, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entry, ok := m.Files[name]
+	return entry, ok
+}
+
+// record adds a downloaded file to the manifest. The manifest is saved every so often, so that not much is lost if
+// the app is closed in the middle of a run.
+func (m *manifest) record(name string, entry manifestEntry) {
+	m.lock.Lock()
+	m.Files[name] = entry
+	m.dirty++
+	save := m.dirty >= 25
+	m.lock.Unlock()
+	if save {
+		if err := m.save(); err != nil {
+			logger.Errorf("Failed to save manifest: %v\n", err)
+		}
+	}
+}
+
+// save writes the manifest to disk, if it changed.
+func (m *manifest) save() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.dirty == 0 || m.path == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempPath := m.path + ".temp"
+	if err := os.WriteFile(tempPath, content, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, m.path); err != nil {
+		return err
+	}
+	m.dirty = 0
+	return nil
+}
+
+// hashFile returns the hex-encoded SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Names that the caption and likes of a post have had in exports, compared by fieldKey.
+var (
+	captionFields = []string{"title", "description", "caption"}
+	likeFields    = []string{"likes", "like(s)"}
+)
+
+func (link *VideoLink) setMetadata(name, value string) {
+	if name == "" || value == "" {
+		return
+	}
+	if link.Metadata == nil {
+		link.Metadata = map[string]string{}
+	}
+	link.Metadata[name] = value
+}
+
+// field returns the value of a field of the post, whatever its name is spelled like in the export, or "" if it
+// doesn't have it. "date", "link", "account", "id", "caption" and "likes" are always available.
+func (link VideoLink) field(name string) string {
+	key := fieldKey(name)
+	switch {
+	case isField(key, dateFields):
+		return link.Date
+	case isField(key, linkFields):
+		return link.Link
+	case key == "account":
+		return link.Account
+	case key == "id":
+		if match := videoIDPattern.FindStringSubmatch(link.Link); match != nil {
+			return match[1]
+		}
+		return ""
+	case key == "caption", key == "likes":
+		fields := captionFields
+		if key == "likes" {
+			fields = likeFields
+		}
+		// In the order of the list, so that the same field wins when a post has several of them.
+		for _, field := range fields {
+			for name, value := range link.Metadata {
+				if fieldKey(name) == field {
+					return value
+				}
+			}
+		}
+		return ""
+	}
+	for metadataName, value := range link.Metadata {
+		if fieldKey(metadataName) == key {
+			return value
+		}
+	}
+	return ""
+}
+
+// defaultFileNameTemplate names videos after the date they were posted, e.g. 2022-11-25-04-23-42.mp4.
+const defaultFileNameTemplate = "{date}"
+
+var templateFieldPattern = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// validateFileNameTemplate checks that a template names every video differently, by including its date or ID.
+func validateFileNameTemplate(template string) error {
+	for _, match := range templateFieldPattern.FindAllStringSubmatch(template, -1) {
+		if key := fieldKey(match[1]); key == "date" || key == "id" {
+			return nil
+		}
+	}
+	return fmt.Errorf("the file name must include {date} or {id}")
+}
+
+// fileNameTemplateOrDefault returns template, or the default one if template is empty or invalid. Templates are
+// strings without the .mp4 extension, where every {field} is replaced with that field of the post (see
+// VideoLink.field).
+func fileNameTemplateOrDefault(template string) string {
+	if template == "" {
+		return defaultFileNameTemplate
+	}
+	if err := validateFileNameTemplate(template); err != nil {
+		logger.Warnf("Ignoring file name template %q: %v\n", template, err)
+		return defaultFileNameTemplate
+	}
+	return template
+}
+
+// maxFileNameLength is the length in bytes that names expanded from templates are cut to, since captions can be long
+// and file names can't.
+const maxFileNameLength = 150
+
+// expandFileNameTemplate returns the name of a post's video according to template, without its extension. If the
+// name is too long, the values of fields other than the date and ID are cut, so that names stay unique.
+func expandFileNameTemplate(template string, link VideoLink) string {
+	var parts []string
+	cuttable := map[int]bool{} // Indexes in parts of the values that can be cut.
+	last := 0
+	for _, match := range templateFieldPattern.FindAllStringSubmatchIndex(template, -1) {
+		parts = append(parts, template[last:match[0]])
+		name := template[match[2]:match[3]]
+		value := link.field(name)
+		switch fieldKey(name) {
+		case "date":
+			value = strings.NewReplacer(" ", "-", ":", "-").Replace(value)
+		case "id":
+		default:
+			cuttable[len(parts)] = true
+		}
+		parts = append(parts, sanitizeFileName(strings.Join(strings.Fields(value), " ")))
+		last = match[1]
+	}
+	parts = append(parts, template[last:])
+
+	// Cut every value that's too long to the same length, the longest one that lets the name fit.
+	lengthWithLimit := func(limit int) int {
+		length := 0
+		for i, part := range parts {
+			if cuttable[i] && len(part) > limit {
+				length += limit
+			} else {
+				length += len(part)
+			}
+		}
+		return length
+	}
+	limit := 0
+	for i := range cuttable {
+		if len(parts[i]) > limit {
+			limit = len(parts[i])
+		}
+	}
+	for limit > 0 && lengthWithLimit(limit) > maxFileNameLength {
+		limit--
+	}
+	for i := range cuttable {
+		parts[i] = strings.TrimSpace(truncateBytes(parts[i], limit))
+	}
+	name := sanitizeFileName(strings.Join(strings.Fields(strings.Join(parts, "")), " "))
+	return strings.TrimSpace(name)
+}
+
+// truncateBytes cuts s to at most n bytes, without splitting a character.
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// sidecarPath returns the path of the file the details of the video at videoPath are saved to.
+func sidecarPath(videoPath string) string {
+	return strings.TrimSuffix(videoPath, ".mp4") + ".json"
+}
+
+// videoSidecar is the content of a sidecar file.
+type videoSidecar struct {
+	Date     string            `json:"date"`
+	Link     string            `json:"link"`
+	Account  string            `json:"account,omitempty"`
+	Caption  string            `json:"caption,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// writeSidecar saves the details of a post next to its video, so that its caption and the rest aren't lost if the
+// video is moved out of the archive.
+func writeSidecar(videoPath string, link VideoLink) error {
+	content, err := json.MarshalIndent(videoSidecar{
+		Date:     link.Date,
+		Link:     unsignedLink(link.Link),
+		Account:  link.Account,
+		Caption:  link.field("caption"),
+		Metadata: link.Metadata,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sidecarPath(videoPath), content, 0666)
+}
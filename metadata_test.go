@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandFileNameTemplate(t *testing.T) {
+	longCaption := strings.Repeat("très long ", 40)
+	link := VideoLink{
+		Date:     "2022-11-25 04:23:42",
+		Link:     "https://www.tiktokv.com/share/video/7170000000000000001/",
+		Metadata: map[string]string{"Title": "the title", "Description": "the description"},
+	}
+	longLink := link
+	longLink.Metadata = map[string]string{"Caption": longCaption}
+
+	tests := []struct {
+		template string
+		link     VideoLink
+		want     string
+	}{
+		{"{date}", link, "2022-11-25-04-23-42"},
+		{"{date} {caption}", link, "2022-11-25-04-23-42 the title"},
+		{"{id}", link, "7170000000000000001"},
+	}
+	for _, test := range tests {
+		// The caption comes from a map, so check that it doesn't change from one call to the next.
+		for i := 0; i < 20; i++ {
+			if got := expandFileNameTemplate(test.template, test.link); got != test.want {
+				t.Fatalf("expandFileNameTemplate(%q) = %q, want %q", test.template, got, test.want)
+			}
+		}
+	}
+
+	for _, template := range []string{"{caption} {date}", "{caption} {id} {caption}"} {
+		got := expandFileNameTemplate(template, longLink)
+		if len(got) > maxFileNameLength {
+			t.Errorf("expandFileNameTemplate(%q) is %d bytes long, want at most %d", template, len(got), maxFileNameLength)
+		}
+		if !strings.Contains(got, "2022-11-25-04-23-42") && !strings.Contains(got, "7170000000000000001") {
+			t.Errorf("expandFileNameTemplate(%q) = %q lost the date or ID", template, got)
+		}
+		if !strings.HasPrefix(got, "très long") {
+			t.Errorf("expandFileNameTemplate(%q) = %q, want it to start with the caption", template, got)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metrics counts what the downloads do across every run, for the daemon's /metrics endpoint. The numbers about the
+// current run (items by status, speed, ...) come from the progress hub instead.
+var metrics = newDownloadMetrics()
+
+// Kinds of HTTP requests made to TikTok, as the "request" label of metrics.
+const (
+	requestDownload = "download"
+	requestSize     = "size"
+)
+
+type downloadMetrics struct {
+	bytes   atomic.Int64 // Bytes of videos downloaded.
+	retries atomic.Int64 // Download attempts beyond the first of each video.
+
+	lock sync.Mutex
+	// Responses by request kind and status code ("error" if there was none).
+	responses map[[2]string]int64
+	// Time until the response's headers arrive, by request kind.
+	latency map[string]*histogram
+	// Time a successful download takes, from the request until the file is in place.
+	downloads *histogram
+}
+
+func newDownloadMetrics() *downloadMetrics {
+	return &downloadMetrics{
+		responses: map[[2]string]int64{},
+		latency:   map[string]*histogram{},
+		downloads: newHistogram([]float64{1, 2.5, 5, 10, 30, 60, 120, 300}),
+	}
+}
+
+// doRequest sends a request to TikTok, keeping track of its status and latency.
+func doRequest(req *http.Request, kind string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	elapsed := time.Since(start)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	metrics.responses[[2]string{kind, code}]++
+	if metrics.latency[kind] == nil {
+		metrics.latency[kind] = newHistogram([]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+	}
+	metrics.latency[kind].observe(elapsed.Seconds())
+	return resp, err
+}
+
+func (m *downloadMetrics) observeDownload(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.downloads.observe(d.Seconds())
+}
+
+// histogram counts observations in buckets, by their upper bound.
+type histogram struct {
+	bounds []float64
+	counts []int64 // Not cumulative, and with an extra bucket for +Inf.
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	i := sort.SearchFloat64s(h.bounds, value)
+	h.counts[i]++
+	h.sum += value
+	h.count++
+}
+
+// metricsWriter writes metrics in the Prometheus text format.
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (m metricsWriter) describe(name, kind, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a value. labels alternate names and values.
+func (m metricsWriter) sample(name string, value float64, labels ...string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	if len(pairs) > 0 {
+		name += "{" + strings.Join(pairs, ",") + "}"
+	}
+	fmt.Fprintf(m.w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (m metricsWriter) histogram(name string, h *histogram, labels ...string) {
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		m.sample(name+"_bucket", float64(cumulative), append(labels, "le", strconv.FormatFloat(bound, 'g', -1, 64))...)
+	}
+	m.sample(name+"_bucket", float64(h.count), append(labels, "le", "+Inf")...)
+	m.sample(name+"_sum", h.sum, labels...)
+	m.sample(name+"_count", float64(h.count), labels...)
+}
+
+// write writes the download metrics, along with those of the latest run (which may be nil) and the number of active
+// workers.
+func (m *downloadMetrics) write(w io.Writer, run *runProgress, workers int) {
+	out := metricsWriter{w}
+	out.describe("tiktok_archiver_downloaded_bytes_total", "counter", "Bytes of videos downloaded.")
+	out.sample("tiktok_archiver_downloaded_bytes_total", float64(m.bytes.Load()))
+	out.describe("tiktok_archiver_retries_total", "counter", "Download attempts beyond the first of each video.")
+	out.sample("tiktok_archiver_retries_total", float64(m.retries.Load()))
+
+	m.lock.Lock()
+	out.describe("tiktok_archiver_http_responses_total", "counter", `HTTP responses from TikTok, by request and status code ("error" if the request failed).`)
+	keys := make([][2]string, 0, len(m.responses))
+	for key := range m.responses {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		out.sample("tiktok_archiver_http_responses_total", float64(m.responses[key]), "request", key[0], "code", key[1])
+	}
+	out.describe("tiktok_archiver_http_request_duration_seconds", "histogram", "Time until TikTok's response headers arrive.")
+	for _, kind := range []string{requestDownload, requestSize} {
+		if h := m.latency[kind]; h != nil {
+			out.histogram("tiktok_archiver_http_request_duration_seconds", h, "request", kind)
+		}
+	}
+	out.describe("tiktok_archiver_download_duration_seconds", "histogram", "Time a successful download of a video takes.")
+	out.histogram("tiktok_archiver_download_duration_seconds", m.downloads)
+	m.lock.Unlock()
+
+	out.describe("tiktok_archiver_active_workers", "gauge", "Videos being downloaded at the moment.")
+	out.sample("tiktok_archiver_active_workers", float64(workers))
+	var counters progressCounters
+	if run != nil {
+		counters = run.counters(run.itemSnapshots())
+	}
+	out.describe("tiktok_archiver_items", "gauge", "Videos of the latest run, by status.")
+	for i, status := range allStatuses {
+		out.sample("tiktok_archiver_items", float64(counters.StatusCounts[i]), "status", status)
+	}
+	out.describe("tiktok_archiver_run_bytes", "gauge", "Bytes downloaded by the latest run.")
+	out.sample("tiktok_archiver_run_bytes", float64(counters.BytesDone))
+	out.describe("tiktok_archiver_run_bytes_estimated", "gauge", "Estimated size of the latest run, in bytes.")
+	out.sample("tiktok_archiver_run_bytes_estimated", float64(counters.BytesTotal))
+	out.describe("tiktok_archiver_download_rate_bytes_per_second", "gauge", "Current download speed.")
+	out.sample("tiktok_archiver_download_rate_bytes_per_second", float64(counters.BytesPerSecond))
+}
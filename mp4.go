@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// mp4Box is a top-level box (a.k.a. atom) of an MP4 file.
+type mp4Box struct {
+	Type   string
+	Offset int64
+	Size   int64
+}
+
+// readMP4Boxes reads the top-level boxes of an MP4 file of the given length, checking that their sizes add up to the
+// length of the file.
+func readMP4Boxes(r io.ReaderAt, length int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	header := make([]byte, 16)
+	for offset := int64(0); offset < length; {
+		if length-offset < 8 {
+			return boxes, fmt.Errorf("truncated box header at offset %d", offset)
+		}
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return boxes, err
+		}
+		box := mp4Box{
+			Type:   string(header[4:8]),
+			Offset: offset,
+			Size:   int64(binary.BigEndian.Uint32(header[:4])),
+		}
+		headerSize := int64(8)
+		switch box.Size {
+		case 0:
+			// The box extends to the end of the file.
+			box.Size = length - offset
+		case 1:
+			// The real size follows the type, as a 64-bit integer.
+			if length-offset < 16 {
+				return boxes, fmt.Errorf("truncated box header at offset %d", offset)
+			}
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return boxes, err
+			}
+			box.Size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if !isPrintableBoxType(box.Type) {
+			return boxes, fmt.Errorf("invalid box type %q at offset %d", box.Type, offset)
+		}
+		if box.Size < headerSize {
+			return boxes, fmt.Errorf("invalid size %d of %q box at offset %d", box.Size, box.Type, offset)
+		}
+		if offset+box.Size > length {
+			return boxes, fmt.Errorf("%q box at offset %d is truncated: it should be %d bytes, but only %d are left",
+				box.Type, offset, box.Size, length-offset)
+		}
+		boxes = append(boxes, box)
+		offset += box.Size
+	}
+	return boxes, nil
+}
+
+func isPrintableBoxType(t string) bool {
+	for _, c := range []byte(t) {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyMP4 checks that the file at path is a complete MP4 file: it must start with an `ftyp` box, contain `moov` and
+// `mdat` boxes, and its boxes must add up to the length of the file. If expectedSize is positive, the file must also
+// be exactly that long.
+func verifyMP4(path string, expectedSize int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	length := info.Size()
+	if expectedSize > 0 && length != expectedSize {
+		return fmt.Errorf("file is %d bytes, expected %d", length, expectedSize)
+	}
+	if length == 0 {
+		return fmt.Errorf("file is empty")
+	}
+
+	boxes, err := readMP4Boxes(f, length)
+	if len(boxes) == 0 || boxes[0].Type != "ftyp" {
+		start := make([]byte, 16)
+		n, _ := f.ReadAt(start, 0)
+		return fmt.Errorf("not an MP4 file (starts with %q)", start[:n])
+	}
+	if err != nil {
+		return err
+	}
+	found := map[string]bool{}
+	for _, box := range boxes {
+		found[box.Type] = true
+	}
+	for _, required := range []string{"moov", "mdat"} {
+		if !found[required] {
+			return fmt.Errorf("missing %q box", required)
+		}
+	}
+	return nil
+}
+
+// mp4Duration returns the duration of the MP4 file at path, from the `mvhd` box in its `moov` box.
+func mp4Duration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	boxes, err := readMP4Boxes(f, info.Size())
+	if err != nil {
+		return 0, err
+	}
+	for _, moov := range boxes {
+		if moov.Type != "moov" {
+			continue
+		}
+		// The boxes in `moov` are laid out the same way as the top-level ones. (Its size is assumed to fit in the
+		// 32-bit field, which is always the case in practice.)
+		children, err := readMP4Boxes(io.NewSectionReader(f, moov.Offset+8, moov.Size-8), moov.Size-8)
+		if err != nil {
+			return 0, err
+		}
+		for _, mvhd := range children {
+			if mvhd.Type != "mvhd" {
+				continue
+			}
+			header := make([]byte, 32)
+			n, err := f.ReadAt(header, moov.Offset+8+mvhd.Offset+8)
+			if n < 20 {
+				return 0, fmt.Errorf("truncated mvhd box: %v", err)
+			}
+			var timescale, duration uint64
+			if header[0] == 1 {
+				// Version 1 has 64-bit times.
+				if n < 32 {
+					return 0, fmt.Errorf("truncated mvhd box: %v", err)
+				}
+				timescale = uint64(binary.BigEndian.Uint32(header[20:24]))
+				duration = binary.BigEndian.Uint64(header[24:32])
+			} else {
+				timescale = uint64(binary.BigEndian.Uint32(header[12:16]))
+				duration = uint64(binary.BigEndian.Uint32(header[16:20]))
+			}
+			if timescale == 0 {
+				return 0, fmt.Errorf("invalid timescale in mvhd box")
+			}
+			return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), nil
+		}
+	}
+	return 0, fmt.Errorf("missing %q box", "mvhd")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// box returns an MP4 box of the given type around body, with a 32-bit size.
+func box(boxType string, body ...[]byte) []byte {
+	content := bytes.Join(body, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(content)))
+	return append(append(b, boxType...), content...)
+}
+
+// largeBox returns an MP4 box of the given type around body, with its size in the 64-bit field.
+func largeBox(boxType string, body []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, 1)
+	b = append(b, boxType...)
+	b = binary.BigEndian.AppendUint64(b, uint64(16+len(body)))
+	return append(b, body...)
+}
+
+// mvhd returns a version 0 `mvhd` box for a movie of the given duration in timescale units.
+func mvhd(timescale, duration uint32) []byte {
+	body := make([]byte, 100)
+	binary.BigEndian.PutUint32(body[12:16], timescale)
+	binary.BigEndian.PutUint32(body[16:20], duration)
+	return box("mvhd", body)
+}
+
+var (
+	testFtyp = box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	testMoov = box("moov", mvhd(1000, 12500), box("trak", []byte("...")))
+	testMdat = box("mdat", bytes.Repeat([]byte{'v'}, 64))
+)
+
+func concat(boxes ...[]byte) []byte {
+	return bytes.Join(boxes, nil)
+}
+
+func TestReadMP4Boxes(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  []byte
+		boxes string // Types of the boxes read, even if there's an error.
+		err   bool
+	}{
+		{name: "complete", file: concat(testFtyp, testMoov, testMdat), boxes: "ftyp moov mdat"},
+		{name: "64-bit size", file: concat(testFtyp, testMoov, largeBox("mdat", []byte("video"))), boxes: "ftyp moov mdat"},
+		{name: "size 0", file: concat(testFtyp, testMoov, []byte("\x00\x00\x00\x00mdatvideo")), boxes: "ftyp moov mdat"},
+		{name: "truncated box", file: concat(testFtyp, testMoov, testMdat[:40]), boxes: "ftyp moov", err: true},
+		{name: "truncated header", file: concat(testFtyp, testMoov, testMdat[:6]), boxes: "ftyp moov", err: true},
+		{name: "truncated 64-bit header", file: concat(testFtyp, largeBox("mdat", nil)[:12]), boxes: "ftyp", err: true},
+		{name: "size smaller than header", file: concat(testFtyp, []byte("\x00\x00\x00\x04mdat"), testMdat), boxes: "ftyp", err: true},
+		{name: "64-bit size smaller than header", file: concat(testFtyp, []byte("\x00\x00\x00\x01mdat\x00\x00\x00\x00\x00\x00\x00\x08")),
+			boxes: "ftyp", err: true},
+		{name: "invalid type", file: concat(testFtyp, []byte("\x00\x00\x00\x08\x00\x01\x02\x03")), boxes: "ftyp", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			boxes, err := readMP4Boxes(bytes.NewReader(test.file), int64(len(test.file)))
+			if test.err && err == nil {
+				t.Errorf("read %d boxes, want an error", len(boxes))
+			} else if !test.err && err != nil {
+				t.Errorf("error %v, want none", err)
+			}
+			var types []string
+			var end int64
+			for _, box := range boxes {
+				types = append(types, box.Type)
+				if box.Offset != end {
+					t.Errorf("%q box is at offset %d, want %d", box.Type, box.Offset, end)
+				}
+				end = box.Offset + box.Size
+			}
+			if got := strings.Join(types, " "); got != test.boxes {
+				t.Errorf("boxes are %q, want %q", got, test.boxes)
+			}
+			if !test.err && end != int64(len(test.file)) {
+				t.Errorf("boxes end at %d, want %d", end, len(test.file))
+			}
+		})
+	}
+}
+
+func TestVerifyMP4(t *testing.T) {
+	complete := concat(testFtyp, testMoov, testMdat)
+	tests := []struct {
+		name         string
+		file         []byte
+		expectedSize int64
+		err          string // Part of the error, if any.
+	}{
+		{name: "complete", file: complete},
+		{name: "expected size", file: complete, expectedSize: int64(len(complete))},
+		{name: "64-bit size", file: concat(testFtyp, testMoov, largeBox("mdat", []byte("video")))},
+		{name: "unexpected size", file: complete, expectedSize: int64(len(complete)) + 1, err: "expected"},
+		{name: "empty", file: nil, err: "empty"},
+		{name: "not an MP4", file: []byte("<html><body>Access denied</body></html>"), err: "not an MP4"},
+		{name: "truncated box", file: complete[:len(complete)-10], err: "truncated"},
+		{name: "size smaller than header", file: concat(testFtyp, []byte("\x00\x00\x00\x04moov"), testMdat), err: "invalid size"},
+		{name: "missing moov", file: concat(testFtyp, testMdat), err: `missing "moov"`},
+		{name: "missing mdat", file: concat(testFtyp, testMoov), err: `missing "mdat"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(path, test.file, 0666); err != nil {
+				t.Fatal(err)
+			}
+			err := verifyMP4(path, test.expectedSize)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("error %v, want none", err)
+			case test.err != "" && err == nil:
+				t.Errorf("no error, want one about %q", test.err)
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("error %v, want one about %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestMP4Duration(t *testing.T) {
+	mvhdV1 := make([]byte, 112)
+	mvhdV1[0] = 1
+	binary.BigEndian.PutUint32(mvhdV1[20:24], 90000)
+	binary.BigEndian.PutUint64(mvhdV1[24:32], 90000*61)
+	tests := []struct {
+		name     string
+		file     []byte
+		duration time.Duration
+		err      bool
+	}{
+		{name: "version 0", file: concat(testFtyp, testMoov, testMdat), duration: 12500 * time.Millisecond},
+		{name: "version 1", file: concat(testFtyp, box("moov", box("mvhd", mvhdV1)), testMdat), duration: 61 * time.Second},
+		{name: "moov after mdat", file: concat(testFtyp, testMdat, box("moov", box("trak"), mvhd(600, 300))),
+			duration: 500 * time.Millisecond},
+		{name: "missing moov", file: concat(testFtyp, testMdat), err: true},
+		{name: "missing mvhd", file: concat(testFtyp, box("moov", box("trak")), testMdat), err: true},
+		{name: "zero timescale", file: concat(testFtyp, box("moov", mvhd(0, 300)), testMdat), err: true},
+		{name: "truncated mvhd", file: concat(testFtyp, box("moov", box("mvhd", make([]byte, 8)))), err: true},
+		{name: "truncated box", file: concat(testFtyp, testMoov, testMdat[:40]), err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(path, test.file, 0666); err != nil {
+				t.Fatal(err)
+			}
+			duration, err := mp4Duration(path)
+			if test.err {
+				if err == nil {
+					t.Errorf("duration is %v, want an error", duration)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if duration != test.duration {
+				t.Errorf("duration is %v, want %v", duration, test.duration)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The parsers below stream their input, so that memory use doesn't depend on the size of the export: user_data.json
+// also holds the DMs, comments and browsing history of the account, and can be hundreds of megabytes.
+//
+// TikTok has changed the layout of both files a few times, so the parsers accept every layout they know of, ignore
+// fields they don't know, and record a warning for each post they have to skip.
+
+// userDataSchema is a layout of user_data.json that TikTok has used.
+type userDataSchema struct {
+	Path []string // Path to the list of videos.
+}
+
+// userDataSchemas lists the known layouts of user_data.json, newest first.
+var userDataSchemas = []userDataSchema{
+	{Path: []string{"Post", "Posts", "VideoList"}},
+	{Path: []string{"Video", "Videos", "VideoList"}},
+}
+
+func (schema userDataSchema) String() string {
+	return strings.Join(schema.Path, ".")
+}
+
+// Names that the date and link of a post have had, compared by fieldKey.
+var (
+	dateFields = []string{"date"}
+	linkFields = []string{"link", "videolink"}
+)
+
+// fieldKey normalizes the name of a field, so that "Video Link", "VideoLink" and "videoLink" are the same field.
+func fieldKey(name string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", ""))
+}
+
+func isField(name string, fields []string) bool {
+	key := fieldKey(name)
+	for _, field := range fields {
+		if key == field {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWarning is a problem found in an export that didn't stop it from being read, e.g. a post that was skipped.
+type parseWarning struct {
+	Line    int    // Line number in Posts.txt, or 0.
+	Path    string // JSON path in user_data.json, or "".
+	Message string
+}
+
+func (w parseWarning) String() string {
+	switch {
+	case w.Line > 0:
+		return fmt.Sprintf("line %d: %s", w.Line, w.Message)
+	case w.Path != "":
+		return fmt.Sprintf("%s: %s", w.Path, w.Message)
+	}
+	return w.Message
+}
+
+// parseReport describes how an export was read.
+type parseReport struct {
+	Schema   string // The layout the export was recognized as.
+	Warnings []parseWarning
+}
+
+func (report *parseReport) warnLine(line int, format string, args ...interface{}) {
+	report.Warnings = append(report.Warnings, parseWarning{Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (report *parseReport) warnPath(path string, format string, args ...interface{}) {
+	report.Warnings = append(report.Warnings, parseWarning{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// skipBOM skips the byte order mark that some editors add to the start of files.
+func skipBOM(r io.Reader) *bufio.Reader {
+	reader := bufio.NewReader(r)
+	if start, err := reader.Peek(3); err == nil && bytes.Equal(start, []byte("\xef\xbb\xbf")) {
+		reader.Discard(3)
+	}
+	return reader
+}
+
+// parsePosts reads a Posts.txt file, calling yield with each of its videos in order. Each post is a "Date:" line and
+// a "Link:" line, in either order, along with any other fields, which are kept in its metadata. A post ends at a blank
+// line once it has both a date and a link, or where the next one starts.
+func parsePosts(r io.Reader, yield func(VideoLink) error, report *parseReport) error {
+	report.Schema = "Posts.txt"
+	scanner := bufio.NewScanner(skipBOM(r))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var post VideoLink
+	start := 0 // Line where the current post started, or 0 if there isn't any.
+	finish := func() error {
+		defer func() {
+			post, start = VideoLink{}, 0
+		}()
+		switch {
+		case start == 0:
+			return nil
+		case post.Link == "":
+			report.warnLine(start, "post of %s has no link, skipped", post.Date)
+		case post.Date == "":
+			report.warnLine(start, "link %s has no date, skipped", post.Link)
+		default:
+			return yield(post)
+		}
+		return nil
+	}
+
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" && post.Date != "" && post.Link != "" {
+			if err := finish(); err != nil {
+				return err
+			}
+			continue
+		}
+		name, value, ok := strings.Cut(text, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		var field *string
+		switch {
+		case isField(name, dateFields):
+			field = &post.Date
+		case isField(name, linkFields):
+			field = &post.Link
+		default:
+			post.setMetadata(strings.TrimSpace(name), value)
+			continue
+		}
+		if *field != "" {
+			// A field of the next post
+			if err := finish(); err != nil {
+				return err
+			}
+		}
+		if start == 0 {
+			start = line
+		}
+		*field = value
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return finish()
+}
+
+// parseUserData reads a user_data.json file, calling yield with each of its videos in order. Only the lists of videos
+// at the paths of the known schemas are decoded; everything else is skipped over token by token.
+func parseUserData(r io.Reader, yield func(VideoLink) error, report *parseReport) error {
+	decoder := json.NewDecoder(skipBOM(r))
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("expected an object, found %v", token)
+	}
+	return walkUserData(decoder, nil, yield, report)
+}
+
+// walkUserData walks the rest of an object whose opening brace was read, at path, looking for lists of videos.
+func walkUserData(decoder *json.Decoder, path []string, yield func(VideoLink) error, report *parseReport) error {
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := token.(string)
+		childPath := append(path[:len(path):len(path)], key)
+		schema, isList, isPrefix := matchUserDataSchema(childPath)
+
+		token, err = decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch {
+		case isList && token == json.Delim('['):
+			if report.Schema != "" {
+				report.Schema += ", "
+			}
+			report.Schema += schema.String()
+			if err := parseVideoList(decoder, strings.Join(childPath, "."), yield, report); err != nil {
+				return err
+			}
+		case isPrefix && token == json.Delim('{'):
+			if err := walkUserData(decoder, childPath, yield, report); err != nil {
+				return err
+			}
+		default:
+			if err := skipJSONValue(decoder, token); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := decoder.Token() // }
+	return err
+}
+
+// matchUserDataSchema tells whether path is the path to the list of videos of a known schema, or leads to one.
+func matchUserDataSchema(path []string) (schema userDataSchema, isList, isPrefix bool) {
+	for _, schema := range userDataSchemas {
+		if len(path) > len(schema.Path) {
+			continue
+		}
+		matches := true
+		for i := range path {
+			matches = matches && strings.EqualFold(path[i], schema.Path[i])
+		}
+		if !matches {
+			continue
+		}
+		if len(path) == len(schema.Path) {
+			return schema, true, false
+		}
+		isPrefix = true
+	}
+	return userDataSchema{}, false, isPrefix
+}
+
+// parseVideoList reads the rest of a list of videos whose opening bracket was read.
+func parseVideoList(decoder *json.Decoder, path string, yield func(VideoLink) error, report *parseReport) error {
+	for i := 0; decoder.More(); i++ {
+		entryPath := path + "[" + strconv.Itoa(i) + "]"
+		var entry map[string]json.RawMessage
+		if err := decoder.Decode(&entry); err != nil {
+			if _, ok := err.(*json.UnmarshalTypeError); ok {
+				report.warnPath(entryPath, "not an object, skipped")
+				continue
+			}
+			return err
+		}
+		var post VideoLink
+		for name, value := range entry {
+			var field *string
+			switch {
+			case isField(name, dateFields):
+				field = &post.Date
+			case isField(name, linkFields):
+				field = &post.Link
+			default:
+				// Other fields are kept as they are, or as JSON if they aren't strings.
+				var text string
+				if err := json.Unmarshal(value, &text); err != nil {
+					var compact bytes.Buffer
+					if json.Compact(&compact, value) == nil {
+						text = compact.String()
+					}
+				}
+				post.setMetadata(name, text)
+				continue
+			}
+			if err := json.Unmarshal(value, field); err != nil {
+				report.warnPath(entryPath+"."+name, "not a string: %s", value)
+			}
+			*field = strings.TrimSpace(*field)
+		}
+		switch {
+		case post.Link == "" && post.Date == "":
+			report.warnPath(entryPath, "post has no date or link, skipped")
+		case post.Link == "":
+			report.warnPath(entryPath, "post of %s has no link, skipped", post.Date)
+		case post.Date == "":
+			report.warnPath(entryPath, "link %s has no date, skipped", post.Link)
+		default:
+			if err := yield(post); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := decoder.Token() // ]
+	return err
+}
+
+// skipJSONValue skips over the rest of a value whose first token was read, however deeply nested it is.
+func skipJSONValue(decoder *json.Decoder, token json.Token) error {
+	depth := 0
+	for {
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+		var err error
+		if token, err = decoder.Token(); err != nil {
+			return err
+		}
+	}
+}
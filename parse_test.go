@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestParseExports reads the fixtures of testdata/exports, expecting what its README lists.
+func TestParseExports(t *testing.T) {
+	tests := []struct {
+		file     string
+		format   string
+		posts    int
+		warnings []string // Line numbers or JSON paths of the warnings, in order.
+		err      bool
+	}{
+		{file: "Posts.txt", format: "Posts.txt", posts: 3},
+		{file: "Posts-bom-crlf.txt", format: "Posts.txt", posts: 2},
+		{file: "Posts-extra-fields.txt", format: "Posts.txt", posts: 3},
+		{file: "Posts-missing-fields.txt", format: "Posts.txt", posts: 2, warnings: []string{"4", "10", "12"}},
+		{file: "user_data-video.json", format: "Video.Videos.VideoList", posts: 2},
+		{file: "user_data-post.json", format: "Post.Posts.VideoList", posts: 2},
+		{file: "user_data-bom.json", format: "Video.Videos.VideoList", posts: 1},
+		{file: "user_data-bad-entries.json", format: "Video.Videos.VideoList", posts: 2, warnings: []string{
+			"Video.Videos.VideoList[1]",
+			"Video.Videos.VideoList[2].Date",
+			"Video.Videos.VideoList[2]",
+			"Video.Videos.VideoList[3]",
+			"Video.Videos.VideoList[4]",
+		}},
+		{file: "user_data-empty.json", err: true},
+	}
+	logger.SetOutput(io.Discard)
+	defer logger.SetOutput(os.Stdout)
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			path := filepath.Join("testdata", "exports", test.file)
+			links, report, err := readAndParseFile(path, detectFileType(path))
+			if test.err {
+				if err == nil {
+					t.Fatalf("read %d posts, want an error", len(links))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Schema != test.format {
+				t.Errorf("format is %q, want %q", report.Schema, test.format)
+			}
+			if len(links) != test.posts {
+				t.Errorf("read %d posts, want %d", len(links), test.posts)
+			}
+			var warnings []string
+			for _, warning := range report.Warnings {
+				if warning.Line > 0 {
+					warnings = append(warnings, strconv.Itoa(warning.Line))
+				} else {
+					warnings = append(warnings, warning.Path)
+				}
+			}
+			if strings.Join(warnings, ", ") != strings.Join(test.warnings, ", ") {
+				t.Errorf("warnings are at %v, want %v", warnings, test.warnings)
+			}
+		})
+	}
+}
+
+// Number of posts in the generated exports of the benchmarks, which makes each of them a few megabytes.
+const benchmarkPosts = 20000
+
+// generatePosts returns a Posts.txt with n posts.
+func generatePosts(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "Date: 2023-%02d-%02d %02d:%02d:%02d\n", i%12+1, i%28+1, i%24, i%60, i%60)
+		fmt.Fprintf(&b, "Link: https://www.tiktokv.com/share/video/72%017d/\n", i)
+		fmt.Fprintf(&b, "Like(s): %d\nWho can view: Everyone\nSound: original sound - someone\n", i%1000)
+		fmt.Fprintf(&b, "Title: Video number %d of the benchmark #archive #test\n\n", i)
+	}
+	return b.Bytes()
+}
+
+// generateUserData returns a user_data.json with n posts, after a browsing history of as many videos that has to be
+// skipped over.
+func generateUserData(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"Activity": {"Video Browsing History": {"VideoList": [`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"Date": "2023-01-01 00:00:00", "VideoLink": "https://www.tiktokv.com/share/video/71%017d/"}`, i)
+	}
+	b.WriteString(`]}}, "Post": {"Posts": {"VideoList": [`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(",\n")
+		}
+		fmt.Fprintf(&b, `{"Date": "2023-%02d-%02d %02d:%02d:%02d", "Link": "https://www.tiktokv.com/share/video/72%017d/", `,
+			i%12+1, i%28+1, i%24, i%60, i%60, i)
+		fmt.Fprintf(&b, `"Likes": "%d", "WhoCanView": "Everyone", "Sound": "original sound - someone", `, i%1000)
+		fmt.Fprintf(&b, `"Title": "Video number %d of the benchmark #archive #test", "CoverImage": {"Url": "https://example.com/cover.jpg"}}`, i)
+	}
+	b.WriteString(`]}}}`)
+	return b.Bytes()
+}
+
+func benchmarkParse(b *testing.B, input []byte, parse func(io.Reader, func(VideoLink) error, *parseReport) error) {
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		posts := 0
+		err := parse(bytes.NewReader(input), func(VideoLink) error {
+			posts++
+			return nil
+		}, &parseReport{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if posts != benchmarkPosts {
+			b.Fatalf("read %d posts, want %d", posts, benchmarkPosts)
+		}
+	}
+}
+
+func BenchmarkParsePosts(b *testing.B) {
+	benchmarkParse(b, generatePosts(benchmarkPosts), parsePosts)
+}
+
+func BenchmarkParseUserData(b *testing.B) {
+	benchmarkParse(b, generateUserData(benchmarkPosts), parseUserData)
+}
+
+// BenchmarkReadAndParseFile measures reading an export the way the app does, keeping all of its links.
+func BenchmarkReadAndParseFile(b *testing.B) {
+	logger.SetOutput(io.Discard)
+	defer logger.SetOutput(os.Stdout)
+	for _, export := range []struct {
+		fileType string
+		content  []byte
+	}{
+		{"Posts.txt", generatePosts(benchmarkPosts)},
+		{"user_data.json", generateUserData(benchmarkPosts)},
+	} {
+		path := filepath.Join(b.TempDir(), export.fileType)
+		if err := os.WriteFile(path, export.content, 0666); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(export.fileType, func(b *testing.B) {
+			b.SetBytes(int64(len(export.content)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := readAndParseFile(path, export.fileType); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/mxk/go-flowrate/flowrate"
+)
+
+// Statuses of a single download.
+const (
+	statusQueued     = "queued"
+	statusInProgress = "in progress"
+	statusSucceeded  = "succeeded"
+	statusFailed     = "failed"
+	statusSkipped    = "skipped"
+	statusCancelled  = "cancelled"
+)
+
+// allStatuses lists every status, in the order they're shown in the UI.
+var allStatuses = [...]string{statusQueued, statusInProgress, statusSucceeded, statusFailed, statusSkipped, statusCancelled}
+
+// statusCounts holds the number of items in each status, indexed like allStatuses.
+type statusCounts [len(allStatuses)]int
+
+func (c statusCounts) get(status string) int {
+	for i, s := range allStatuses {
+		if s == status {
+			return c[i]
+		}
+	}
+	return 0
+}
+
+// progressHub is the single source of truth for download progress. The download workers only ever touch atomic
+// counters on it, and subscribers (the GUI, the log, ...) receive coalesced snapshots at their own bounded rate, so
+// that the hot download loop never has to wait on UI bindings or locks.
+type progressHub struct {
+	run atomic.Pointer[runProgress]
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// runProgress tracks a single batch of downloads.
+type runProgress struct {
+	items []*itemProgress
+
+	bytesDone atomic.Int64
+
+	// The monitor is fed from bytesDone by the hub's sampler rather than by the workers directly, since updating it
+	// takes a lock.
+	monitor *flowrate.Monitor
+	sampled int64 // Only accessed by the sampler goroutine.
+}
+
+// itemProgress tracks a single download within a run.
+type itemProgress struct {
+	run            *runProgress
+	name           string
+	path           string
+	date           string
+	link           string
+	bytesDone      atomic.Int64
+	contentLength  atomic.Int64
+	bytesPerSecond atomic.Int64
+	attempts       atomic.Int32
+	status         atomic.Value // string
+	err            atomic.Value // string
+
+	// cancel cancels the item's current download attempt.
+	cancel atomic.Value // context.CancelFunc
+	// force makes the next attempt download the item even if it already exists.
+	force atomic.Bool
+
+	// Only accessed by the sampler goroutine.
+	sampled int64
+	rate    float64
+}
+
+// progressCounters are the run-wide numbers of a snapshot.
+type progressCounters struct {
+	Total          int
+	Completed      int
+	Errors         int
+	Skipped        int
+	BytesDone      int64
+	BytesPerSecond int64
+
+	// BytesTotal is the size of the whole run. Sizes of downloads that haven't started yet are estimated from the
+	// average size of the ones that have.
+	BytesTotal int64
+	// Fraction is the overall progress of the run, between 0 and 1.
+	Fraction float64
+	// ETA is the estimated time until the run finishes, or 0 if unknown.
+	ETA time.Duration
+
+	StatusCounts statusCounts
+}
+
+type itemSnapshot struct {
+	Index          int
+	Name           string
+	Path           string
+	Date           string
+	Link           string
+	Status         string
+	BytesDone      int64
+	ContentLength  int64
+	BytesPerSecond int64
+	Attempts       int
+	Error          string
+}
+
+// progressSnapshot is what subscribers receive. When NewRun is set, Items contains every item of the run; otherwise
+// it only contains the items that changed since the subscriber's previous snapshot.
+type progressSnapshot struct {
+	progressCounters
+	NewRun bool
+	Items  []itemSnapshot
+}
+
+func newProgressHub() *progressHub {
+	h := &progressHub{stop: make(chan struct{})}
+	go h.sample()
+	return h
+}
+
+// close stops the hub's sampler. Subscribers have to unsubscribe separately.
+func (h *progressHub) close() {
+	h.stopOnce.Do(func() { close(h.stop) })
+}
+
+// sample periodically feeds the current run's monitor with the bytes downloaded since the last sample, and updates
+// the speed of each download in progress, until the hub is closed.
+func (h *progressHub) sample() {
+	const interval = 100 * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+		run := h.run.Load()
+		if run == nil {
+			continue
+		}
+		// The run's bytes go down when items are reset, which isn't data the monitor should see.
+		current := run.bytesDone.Load()
+		if current > run.sampled {
+			run.monitor.Update(int(current - run.sampled))
+		}
+		run.sampled = current
+
+		for _, item := range run.items {
+			current := item.bytesDone.Load()
+			if item.getStatus() != statusInProgress {
+				item.sampled, item.rate = current, 0
+				item.bytesPerSecond.Store(0)
+				continue
+			}
+			sample := float64(current-item.sampled) / interval.Seconds()
+			item.sampled = current
+			// Smooth the speed out a little, so that it's readable in the UI.
+			item.rate += (sample - item.rate) * 0.3
+			item.bytesPerSecond.Store(int64(item.rate))
+		}
+	}
+}
+
+// startRun registers a new batch of downloads of the given links to the given paths, and makes it the current run.
+// names are the paths relative to the output directory, see videoName.
+func (h *progressHub) startRun(names, paths []string, links []VideoLink) *runProgress {
+	run := &runProgress{
+		items:   make([]*itemProgress, len(paths)),
+		monitor: flowrate.New(100*time.Millisecond, 1*time.Second),
+	}
+	for i, path := range paths {
+		item := &itemProgress{run: run, name: names[i], path: path, date: links[i].Date, link: links[i].Link}
+		item.status.Store(statusQueued)
+		item.err.Store("")
+		run.items[i] = item
+	}
+	h.run.Store(run)
+	return run
+}
+
+// subscribe calls fn with a snapshot of the progress at most once per interval, and only when something changed.
+// Calls to fn are never concurrent with each other.
+func (h *progressHub) subscribe(interval time.Duration, fn func(progressSnapshot)) (unsubscribe func()) {
+	return subscribeTo(h.run.Load, interval, fn)
+}
+
+// subscribe is like progressHub.subscribe, but follows this run even once it's no longer the current one.
+func (run *runProgress) subscribe(interval time.Duration, fn func(progressSnapshot)) (unsubscribe func()) {
+	return subscribeTo(func() *runProgress { return run }, interval, fn)
+}
+
+// subscribeTo calls fn with snapshots of whichever run current returns.
+func subscribeTo(current func() *runProgress, interval time.Duration, fn func(progressSnapshot)) (unsubscribe func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var lastRun *runProgress
+		var lastCounters progressCounters
+		var lastItems []itemSnapshot
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			run := current()
+			if run == nil {
+				continue
+			}
+			items := run.itemSnapshots()
+			snapshot := progressSnapshot{progressCounters: run.counters(items)}
+			if run != lastRun {
+				snapshot.NewRun = true
+				snapshot.Items = items
+			} else {
+				for i := range items {
+					if items[i] != lastItems[i] {
+						snapshot.Items = append(snapshot.Items, items[i])
+					}
+				}
+				if len(snapshot.Items) == 0 && snapshot.progressCounters == lastCounters {
+					continue
+				}
+			}
+			lastRun, lastCounters, lastItems = run, snapshot.progressCounters, items
+			fn(snapshot)
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func (run *runProgress) counters(items []itemSnapshot) progressCounters {
+	status := run.monitor.Status()
+	counters := progressCounters{
+		Total:          len(run.items),
+		BytesDone:      run.bytesDone.Load(),
+		BytesPerSecond: int64(status.CurRate),
+	}
+
+	// Items whose size is known contribute their bytes. Items that finished without a known size (e.g. skipped ones)
+	// and items that haven't started yet are assumed to be of average size, which blends item counts into the
+	// progress until enough sizes are known.
+	var knownBytes, doneBytes int64
+	var sized, unsizedDone, unsizedPending int
+	for _, item := range items {
+		for i, status := range allStatuses {
+			if item.Status == status {
+				counters.StatusCounts[i]++
+			}
+		}
+		finished := item.Status != statusQueued && item.Status != statusInProgress
+		switch item.Status {
+		case statusSucceeded:
+			counters.Completed++
+		case statusFailed:
+			counters.Completed++
+			counters.Errors++
+		case statusSkipped:
+			counters.Completed++
+			counters.Skipped++
+		}
+		switch {
+		case item.ContentLength > 0:
+			sized++
+			knownBytes += item.ContentLength
+			if finished {
+				doneBytes += item.ContentLength
+			} else {
+				doneBytes += min64(item.BytesDone, item.ContentLength)
+			}
+		case finished:
+			unsizedDone++
+		default:
+			unsizedPending++
+		}
+	}
+	if sized == 0 {
+		if counters.Total > 0 {
+			counters.Fraction = float64(counters.Completed) / float64(counters.Total)
+		}
+		return counters
+	}
+	averageSize := knownBytes / int64(sized)
+	counters.BytesTotal = knownBytes + averageSize*int64(unsizedDone+unsizedPending)
+	doneBytes += averageSize * int64(unsizedDone)
+	if counters.BytesTotal > 0 {
+		counters.Fraction = float64(doneBytes) / float64(counters.BytesTotal)
+	}
+	if remaining := counters.BytesTotal - doneBytes; remaining > 0 && status.AvgRate > 0 {
+		counters.ETA = time.Duration(float64(remaining) / float64(status.AvgRate) * float64(time.Second)).Round(time.Second)
+	}
+	return counters
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (run *runProgress) itemSnapshots() []itemSnapshot {
+	items := make([]itemSnapshot, len(run.items))
+	for i, item := range run.items {
+		items[i] = itemSnapshot{
+			Index:          i,
+			Name:           item.name,
+			Path:           item.path,
+			Date:           item.date,
+			Link:           item.link,
+			Status:         item.getStatus(),
+			BytesDone:      item.bytesDone.Load(),
+			ContentLength:  item.contentLength.Load(),
+			BytesPerSecond: item.bytesPerSecond.Load(),
+			Attempts:       int(item.attempts.Load()),
+			Error:          item.err.Load().(string),
+		}
+	}
+	return items
+}
+
+func (item *itemProgress) addBytes(n int64) {
+	item.bytesDone.Add(n)
+	item.run.bytesDone.Add(n)
+}
+
+func (item *itemProgress) getStatus() string {
+	return item.status.Load().(string)
+}
+
+func (item *itemProgress) setStatus(status string) {
+	item.status.Store(status)
+}
+
+// fail marks the item as failed because of err.
+func (item *itemProgress) fail(err error) {
+	item.err.Store(err.Error())
+	item.setStatus(statusFailed)
+}
+
+// reset puts a finished item back in the queued state, so it can be downloaded again.
+func (item *itemProgress) reset() {
+	// The bytes of the previous attempt no longer count towards the run either.
+	item.run.bytesDone.Add(-item.bytesDone.Swap(0))
+	item.contentLength.Store(0)
+	item.err.Store("")
+	item.setStatus(statusQueued)
+}
+
+// cancelDownload cancels the item's current download attempt, if any.
+func (item *itemProgress) cancelDownload() {
+	if cancel, ok := item.cancel.Load().(context.CancelFunc); ok {
+		cancel()
+	}
+}
+
+// fraction returns how much of the item has been downloaded, between 0 and 1.
+func (s itemSnapshot) fraction() float64 {
+	if s.Status == statusSucceeded || s.Status == statusSkipped {
+		return 1
+	}
+	if s.ContentLength <= 0 {
+		return 0
+	}
+	return float64(s.BytesDone) / float64(s.ContentLength)
+}
+
+// details describes the item's byte counts, speed, attempts and error for display.
+func (s itemSnapshot) details() string {
+	var parts []string
+	switch {
+	case s.ContentLength > 0:
+		parts = append(parts, fmt.Sprintf("%s / %s", humanize.Bytes(uint64(s.BytesDone)), humanize.Bytes(uint64(s.ContentLength))))
+	case s.BytesDone > 0:
+		parts = append(parts, humanize.Bytes(uint64(s.BytesDone)))
+	}
+	if s.BytesPerSecond > 0 {
+		parts = append(parts, fmt.Sprintf("%s/s", humanize.Bytes(uint64(s.BytesPerSecond))))
+	}
+	if s.Attempts > 1 {
+		parts = append(parts, fmt.Sprintf("attempt %d", s.Attempts))
+	}
+	if s.Error != "" {
+		parts = append(parts, s.Error)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// logProgress is a progress subscriber that writes a summary line to the log.
+func logProgress(snapshot progressSnapshot) {
+	logger.Infof("Progress: %d / %d videos processed (%d errors, %d skipped), %s downloaded at %s/s\n",
+		snapshot.Completed, snapshot.Total, snapshot.Errors, snapshot.Skipped,
+		humanize.Bytes(uint64(snapshot.BytesDone)), humanize.Bytes(uint64(snapshot.BytesPerSecond)))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2/data/binding"
+	"github.com/mxk/go-flowrate/flowrate"
+)
+
+const (
+	benchmarkVideoSize  = 4 << 20
+	benchmarkDownloads  = 8
+	benchmarkChunkBytes = 4096
+)
+
+// newVideoServer serves a video of the given size at every path.
+func newVideoServer(size int) *httptest.Server {
+	video := bytes.Repeat([]byte{'v'}, size)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(video)))
+		w.Write(video)
+	}))
+}
+
+// fetchChunks downloads url in chunks like downloadFile does, writing each chunk to w.
+func fetchChunks(url string, w io.Writer) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf := make([]byte, benchmarkChunkBytes)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			w.Write(buf[:n])
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// benchmarkProgress runs benchmarkDownloads concurrent downloads per iteration, reporting the progress of download i
+// to writer(i).
+func benchmarkProgress(b *testing.B, start func() (writer func(i int) io.Writer, stop func())) {
+	server := newVideoServer(benchmarkVideoSize)
+	defer server.Close()
+	b.SetBytes(benchmarkVideoSize * benchmarkDownloads)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		writer, stop := start()
+		var wg sync.WaitGroup
+		for i := 0; i < benchmarkDownloads; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := fetchChunks(server.URL+"/"+strconv.Itoa(i), writer(i)); err != nil {
+					b.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		stop()
+	}
+}
+
+// BenchmarkProgressHub reports progress through the hub, with a subscriber updating bindings like the window does.
+func BenchmarkProgressHub(b *testing.B) {
+	benchmarkProgress(b, func() (func(int) io.Writer, func()) {
+		hub := newProgressHub()
+		names := make([]string, benchmarkDownloads)
+		links := make([]VideoLink, benchmarkDownloads)
+		run := hub.startRun(names, names, links)
+		progress := make([]binding.Float, benchmarkDownloads)
+		for i := range progress {
+			progress[i] = binding.NewFloat()
+		}
+		unsubscribe := hub.subscribe(100*time.Millisecond, func(snapshot progressSnapshot) {
+			for _, item := range snapshot.Items {
+				progress[item.Index].Set(item.fraction())
+			}
+		})
+		writer := func(i int) io.Writer {
+			run.items[i].contentLength.Store(benchmarkVideoSize)
+			return &WriteCounter{ContentLength: benchmarkVideoSize, Progress: run.items[i]}
+		}
+		return writer, func() {
+			unsubscribe()
+			hub.close()
+		}
+	})
+}
+
+// bindingWriteCounter is how progress used to be reported: a binding and a shared monitor updated on every chunk.
+type bindingWriteCounter struct {
+	total    int64
+	progress binding.Float
+	monitor  *flowrate.Monitor
+}
+
+func (wc *bindingWriteCounter) Write(p []byte) (int, error) {
+	wc.total += int64(len(p))
+	wc.progress.Set(float64(wc.total) / benchmarkVideoSize)
+	wc.monitor.Update(len(p))
+	return len(p), nil
+}
+
+// BenchmarkProgressBindings reports progress by updating bindings for every chunk, as before the hub.
+func BenchmarkProgressBindings(b *testing.B) {
+	benchmarkProgress(b, func() (func(int) io.Writer, func()) {
+		monitor := flowrate.New(100*time.Millisecond, time.Second)
+		writer := func(i int) io.Writer {
+			return &bindingWriteCounter{progress: binding.NewFloat(), monitor: monitor}
+		}
+		return writer, func() {}
+	})
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// defaultServePort is the port the archive is served on, unless another one is picked.
+const defaultServePort = 8765
+
+// archiveServer serves an output directory over HTTP: the gallery as its HTML UI, the files themselves (with support
+// for Range requests, so that videos can be seeked), and JSON endpoints for the list of videos and the catalog.
+type archiveServer struct {
+	dir   string
+	token string // Required from clients if not empty.
+	urls  []string
+
+	server *http.Server
+}
+
+// serverVideo is an entry of /api/videos.
+type serverVideo struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+
+	Date    string `json:"date,omitempty"`
+	Link    string `json:"link,omitempty"`
+	Account string `json:"account,omitempty"`
+	Caption string `json:"caption,omitempty"`
+	Likes   string `json:"likes,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// newToken returns a random token to protect a server with.
+func newToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// startArchiveServer serves dir on the given port, of localhost only, or of every network interface if lan is set.
+// A token is required when serving the LAN; one is generated if token is empty.
+func startArchiveServer(dir string, port int, lan bool, token string) (*archiveServer, error) {
+	host := "127.0.0.1"
+	if lan {
+		host = ""
+		if token == "" {
+			token = newToken()
+		}
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	if err := buildGallery(dir); err != nil {
+		logger.Errorf("Failed to update the gallery: %v\n", err)
+	}
+
+	s := &archiveServer{dir: dir, token: token}
+	s.server = &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	port = listener.Addr().(*net.TCPAddr).Port
+	hosts := []string{"localhost"}
+	if lan {
+		hosts = append(hosts, lanAddresses()...)
+	}
+	for _, host := range hosts {
+		address := fmt.Sprintf("http://%s/", net.JoinHostPort(host, strconv.Itoa(port)))
+		if token != "" {
+			address += "?token=" + token
+		}
+		s.urls = append(s.urls, address)
+	}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Archive server stopped: %v\n", err)
+		}
+	}()
+	logger.Infof("Serving %s at %s\n", dir, strings.Join(s.urls, ", "))
+	return s, nil
+}
+
+// stop shuts the server down, closing open connections.
+func (s *archiveServer) stop() error {
+	logger.Infof("Stopped serving %s\n", s.dir)
+	return s.server.Close()
+}
+
+// lanAddresses returns the IPv4 addresses of this computer on the local network.
+func lanAddresses() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logger.Errorf("Failed to list network addresses: %v\n", err)
+		return nil
+	}
+	var hosts []string
+	for _, addr := range addrs {
+		if ip, ok := addr.(*net.IPNet); ok && !ip.IP.IsLoopback() && ip.IP.To4() != nil {
+			hosts = append(hosts, ip.IP.String())
+		}
+	}
+	return hosts
+}
+
+func (s *archiveServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/videos", s.serveVideos)
+	mux.HandleFunc("/api/catalog", s.serveCatalog)
+	mux.HandleFunc("/api/catalog/exports", s.serveCatalog)
+	mux.HandleFunc("/api/catalog/posts", s.serveCatalog)
+	// http.FileServer handles Range requests and serves index.html (the gallery) for /.
+	mux.Handle("/", http.FileServer(http.Dir(s.dir)))
+	return requireToken(s.token, mux)
+}
+
+// requireToken checks the token of requests, if token isn't empty. It can be given as a "token" query parameter
+// (which is then kept in a cookie, so that the links of the gallery work), a cookie, or a bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		given := r.URL.Query().Get("token")
+		if given == "" {
+			given = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if given == "" {
+			if cookie, err := r.Cookie("token"); err == nil {
+				given = cookie.Value
+			}
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "A valid token is required.", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("token") != "" {
+			http.SetCookie(w, &http.Cookie{Name: "token", Value: given, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveVideos lists the videos in the archive, along with what the catalog knows about them.
+func (s *archiveServer) serveVideos(w http.ResponseWriter, r *http.Request) {
+	files, err := listFiles(s.dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c, err := loadCatalog(s.dir)
+	if err != nil {
+		logger.Errorf("Failed to read catalog: %v\n", err)
+	}
+	byFileName := map[string]*catalogPost{}
+	for _, post := range c.Posts {
+		byFileName[post.FileName] = post
+	}
+	videos := []serverVideo{}
+	for name, info := range files {
+		if !strings.EqualFold(filepath.Ext(name), ".mp4") {
+			continue
+		}
+		video := serverVideo{Name: name, URL: (&url.URL{Path: "/" + name}).String(), Size: info.Size(), Modified: info.ModTime()}
+		if post := byFileName[name]; post != nil {
+			link := VideoLink{Date: post.Date, Link: post.Link, Account: post.Account, Metadata: post.Metadata}
+			video.Date, video.Link, video.Account = post.Date, unsignedLink(post.Link), post.Account
+			video.Caption, video.Likes, video.Deleted = link.field("caption"), link.field("likes"), post.Deleted
+		}
+		videos = append(videos, video)
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].Name > videos[j].Name
+	})
+	writeJSONResponse(w, videos)
+}
+
+// serveCatalog serves the whole catalog, or only its exports or posts. Posts can be filtered with the "account",
+// "deleted" and "q" (caption or date) query parameters.
+func (s *archiveServer) serveCatalog(w http.ResponseWriter, r *http.Request) {
+	c, err := loadCatalog(s.dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch r.URL.Path {
+	case "/api/catalog/exports":
+		writeJSONResponse(w, c.Exports)
+	case "/api/catalog/posts":
+		query := r.URL.Query()
+		posts := []*catalogPost{}
+		for _, post := range c.Posts {
+			if account, ok := query["account"]; ok && post.Account != account[0] {
+				continue
+			}
+			if deleted := query.Get("deleted"); deleted != "" && strconv.FormatBool(post.Deleted) != deleted {
+				continue
+			}
+			link := VideoLink{Date: post.Date, Account: post.Account, Metadata: post.Metadata}
+			if q := strings.ToLower(query.Get("q")); q != "" &&
+				!strings.Contains(strings.ToLower(post.Date+" "+link.field("caption")), q) {
+				continue
+			}
+			posts = append(posts, post)
+		}
+		sort.Slice(posts, func(i, j int) bool {
+			return posts[i].Date > posts[j].Date
+		})
+		writeJSONResponse(w, posts)
+	default:
+		writeJSONResponse(w, c)
+	}
+}
+
+func writeJSONResponse(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		logger.Errorf("Failed to write response: %v\n", err)
+	}
+}
+
+// serveDirExists checks that dir can be served.
+func serveDirExists(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("You must select a folder to serve.")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a folder", dir)
+	}
+	return nil
+}
+
+// showServeDialog lets the user start and stop serving the output directory.
+func showServeDialog(appState *appState) {
+	outputDir, _ := appState.outputDir.Get()
+	portEntry := widget.NewEntry()
+	portEntry.SetText(strconv.Itoa(defaultServePort))
+	lanCheck := widget.NewCheck("Allow other devices on the network (with a token)", nil)
+	urls := container.NewVBox()
+	var toggleButton *widget.Button
+
+	refresh := func() {
+		urls.RemoveAll()
+		appState.lock.Lock()
+		server := appState.server
+		appState.lock.Unlock()
+		if server == nil {
+			urls.Add(widget.NewLabel("The archive isn't being served."))
+			toggleButton.SetText("Start")
+			portEntry.Enable()
+			lanCheck.Enable()
+			return
+		}
+		for _, address := range server.urls {
+			address := address
+			parsed, _ := url.Parse(address)
+			copyButton := widget.NewButtonWithIcon("", theme.ContentCopyIcon(), func() {
+				appState.window.Clipboard().SetContent(address)
+			})
+			urls.Add(container.NewBorder(nil, nil, nil, copyButton, widget.NewHyperlink(address, parsed)))
+		}
+		toggleButton.SetText("Stop")
+		portEntry.Disable()
+		lanCheck.Disable()
+	}
+	toggleButton = widget.NewButton("", func() {
+		appState.lock.Lock()
+		server := appState.server
+		appState.server = nil
+		appState.lock.Unlock()
+		if server != nil {
+			if err := server.stop(); err != nil {
+				logger.Errorf("Failed to stop the archive server: %v\n", err)
+			}
+			refresh()
+			return
+		}
+		if err := serveDirExists(outputDir); err != nil {
+			dialog.ShowError(err, appState.window)
+			return
+		}
+		port, err := strconv.Atoi(portEntry.Text)
+		if err != nil || port < 0 || port > 65535 {
+			dialog.ShowError(fmt.Errorf("%q is not a valid port.", portEntry.Text), appState.window)
+			return
+		}
+		server, err = startArchiveServer(outputDir, port, lanCheck.Checked, "")
+		if err != nil {
+			logger.Errorf("Failed to serve the archive: %v\n", err)
+			dialog.ShowError(err, appState.window)
+			return
+		}
+		appState.lock.Lock()
+		appState.server = server
+		appState.lock.Unlock()
+		refresh()
+	})
+	refresh()
+
+	message := widget.NewLabel(fmt.Sprintf("Serves %s over HTTP, to browse and play the videos from a browser, or to get the catalog as JSON from /api/catalog and the list of videos from /api/videos.", outputDir))
+	message.Wrapping = fyne.TextWrapWord
+	content := container.NewVBox(
+		message,
+		container.New(layout.NewFormLayout(), widget.NewLabel("Port:"), portEntry),
+		lanCheck,
+		toggleButton,
+		urls,
+	)
+	d := dialog.NewCustom("Serve archive", "Close", content, appState.window)
+	d.Resize(fyne.NewSize(600, 350))
+	d.Show()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// makeRaw makes the console pass keys on as soon as they're pressed, without echoing them, and returns a function
+// that restores it. Ctrl+C still interrupts the process. It also makes the console understand the escape sequences
+// the TUI is drawn with.
+func makeRaw(f *os.File) (restore func(), err error) {
+	in, out := windows.Handle(f.Fd()), windows.Handle(os.Stdout.Fd())
+	var inMode, outMode uint32
+	if err := windows.GetConsoleMode(in, &inMode); err != nil {
+		return nil, err
+	}
+	if err := windows.GetConsoleMode(out, &outMode); err != nil {
+		return nil, err
+	}
+	raw := inMode&^(windows.ENABLE_ECHO_INPUT|windows.ENABLE_LINE_INPUT) | windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(in, raw); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(out, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		_ = windows.SetConsoleMode(in, inMode)
+		return nil, err
+	}
+	return func() {
+		_ = windows.SetConsoleMode(in, inMode)
+		_ = windows.SetConsoleMode(out, outMode)
+	}, nil
+}
+
+// terminalSize returns the number of columns and rows of the console f is.
+func terminalSize(f *os.File) (width, height int, err error) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(f.Fd()), &info); err != nil {
+		return 0, 0, err
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1, nil
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dustin/go-humanize"
+)
+
+// The TUI downloads in the terminal, for when there's no desktop to open the window on (e.g. over SSH). It shows what
+// the window shows, from the same progress model: the input, output and options, the overall progress, and the list
+// of videos, which can be filtered, searched and sorted like the window's.
+
+// tuiFilters are the statuses the list can be filtered by, in the order the filter key cycles through them. "" shows
+// every video.
+var tuiFilters = []string{"", statusFailed, statusInProgress, statusQueued, statusSucceeded, statusSkipped, statusCancelled}
+
+// Lines of the screen above and below the list.
+const (
+	tuiHeaderLines = 9
+	tuiFooterLines = 2
+)
+
+type tui struct {
+	inputs    []exportInput
+	outputDir string
+	options   daemonOptions
+
+	hub       *progressHub
+	downloads *downloadState
+	job       *downloadJob
+
+	// lock guards everything below, which the progress subscriber and onFinish update.
+	lock      sync.Mutex
+	counters  progressCounters
+	finished  bool
+	cancelled bool
+	paused    bool
+
+	// Only accessed by the main loop.
+	row         int // Selected row of the list.
+	scroll      int // First row of the list on screen.
+	filter      int // Index in tuiFilters.
+	sortOrder   int // Index in sortOrders.
+	query       string
+	searching   bool   // Whether keys are typed into the query.
+	message     string // Shown in the status line until the next key.
+	confirmQuit bool
+}
+
+// tuiKey is a key pressed: a character, or the name of a special key, e.g. "up".
+type tuiKey string
+
+// escapeTimeout is how long readKeys waits for the rest of an escape sequence before taking Esc as a key of its own.
+const escapeTimeout = 50 * time.Millisecond
+
+// readKeys reads the keys pressed in the terminal, until it's closed. A key may be split across reads, so what's left
+// of one is kept for the next read, or taken as is if nothing follows.
+func readKeys(f *os.File, keys chan<- tuiKey) {
+	reads := make(chan []byte)
+	go func() {
+		defer close(reads)
+		for {
+			buf := make([]byte, 64)
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			reads <- buf[:n]
+		}
+	}()
+	var pending []byte
+	for {
+		var timeout <-chan time.Time
+		if len(pending) > 0 {
+			timeout = time.After(escapeTimeout)
+		}
+		final := false
+		select {
+		case input, ok := <-reads:
+			if !ok {
+				close(keys)
+				return
+			}
+			pending = append(pending, input...)
+		case <-timeout:
+			final = true
+		}
+		var parsed []tuiKey
+		parsed, pending = parseKeys(pending, final)
+		for _, key := range parsed {
+			keys <- key
+		}
+	}
+}
+
+var escapeSequences = map[string]tuiKey{
+	"\x1b[A": "up", "\x1b[B": "down", "\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+	"\x1b[H": "home", "\x1b[F": "end", "\x1b[1~": "home", "\x1b[4~": "end",
+	"\x1bOA": "up", "\x1bOB": "down", "\x1bOH": "home", "\x1bOF": "end",
+}
+
+// parseKeys returns the keys of input, and what's left of it if it ends in the middle of a key. If final, nothing
+// more is coming, so an unfinished key is taken as is: Esc on its own, or nothing for a partial character.
+func parseKeys(input []byte, final bool) ([]tuiKey, []byte) {
+	var keys []tuiKey
+	for len(input) > 0 {
+		key, size := parseKey(input)
+		if size == 0 {
+			if !final {
+				break
+			}
+			key, size = "", 1
+			if input[0] == 0x1b {
+				key = "esc"
+			}
+		}
+		if key != "" {
+			keys = append(keys, key)
+		}
+		input = input[size:]
+	}
+	return keys, input
+}
+
+// parseKey returns the first key of input, and how many bytes it takes. The size is 0 if input ends before the key
+// does, and the key is "" for escape sequences of keys the TUI doesn't use, which are skipped whole.
+func parseKey(input []byte) (tuiKey, int) {
+	switch input[0] {
+	case 0x1b:
+		return parseEscapeSequence(input)
+	case '\r', '\n':
+		return "enter", 1
+	case 0x7f, 0x08:
+		return "backspace", 1
+	case 0x03:
+		return "ctrl+c", 1
+	}
+	if !utf8.FullRune(input) {
+		return "", 0
+	}
+	r, size := utf8.DecodeRune(input)
+	return tuiKey(string(r)), size
+}
+
+// parseEscapeSequence parses the escape sequence at the start of input, like parseKey. CSI sequences (ESC [) run up to
+// a final byte between @ and ~, after any parameter and intermediate bytes; SS3 sequences (ESC O) are one byte longer.
+// Esc followed by anything else is Esc on its own.
+func parseEscapeSequence(input []byte) (tuiKey, int) {
+	if len(input) < 2 {
+		return "", 0
+	}
+	size := 0
+	switch input[1] {
+	case '[':
+		for i := 2; i < len(input) && size == 0; i++ {
+			switch c := input[i]; {
+			case c >= 0x40 && c <= 0x7e:
+				size = i + 1
+			case c < 0x20 || c > 0x3f:
+				// Not a CSI sequence after all.
+				return "esc", 1
+			}
+		}
+	case 'O':
+		if len(input) >= 3 {
+			size = 3
+		}
+	default:
+		return "esc", 1
+	}
+	if size == 0 {
+		return "", 0
+	}
+	return escapeSequences[string(input[:size])], size
+}
+
+func (t *tui) run() int {
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The TUI needs a terminal: %v\n", err)
+		return 1
+	}
+	defer restore()
+	// Use the terminal's alternate screen, so that whatever was on it comes back afterwards, and hide the cursor.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	unsubscribe := t.hub.subscribe(200*time.Millisecond, func(snapshot progressSnapshot) {
+		t.downloads.apply(snapshot)
+		t.lock.Lock()
+		t.counters = snapshot.progressCounters
+		t.lock.Unlock()
+	})
+	defer unsubscribe()
+
+	keys := make(chan tuiKey)
+	go readKeys(os.Stdin, keys)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		t.draw()
+		select {
+		case key, ok := <-keys:
+			if !ok || !t.handleKey(key) {
+				return t.quit()
+			}
+		case <-interrupt:
+			return t.quit()
+		case <-ticker.C:
+		}
+	}
+}
+
+// quit cancels the downloads in progress, if any, and waits a little for them to wind down so that the manifest is
+// saved.
+func (t *tui) quit() int {
+	t.lock.Lock()
+	finished := t.finished
+	t.lock.Unlock()
+	if finished {
+		return 0
+	}
+	t.job.cancelAll()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		t.lock.Lock()
+		finished := t.finished
+		t.lock.Unlock()
+		if finished {
+			break
+		}
+	}
+	return 1
+}
+
+// handleKey acts on a key, and returns false if the TUI should quit.
+func (t *tui) handleKey(key tuiKey) bool {
+	t.message = ""
+	if t.searching {
+		switch key {
+		case "enter":
+			t.searching = false
+		case "esc":
+			t.searching, t.query = false, ""
+		case "backspace":
+			if t.query != "" {
+				_, size := utf8.DecodeLastRuneInString(t.query)
+				t.query = t.query[:len(t.query)-size]
+			}
+		case "ctrl+c":
+			return false
+		default:
+			if utf8.RuneCountInString(string(key)) == 1 {
+				t.query += string(key)
+			}
+		}
+		t.applyFilter()
+		return true
+	}
+
+	quitting := t.confirmQuit
+	t.confirmQuit = false
+	_, height, _ := t.size()
+	page := height - tuiHeaderLines - tuiFooterLines
+	switch key {
+	case "q", "ctrl+c":
+		t.lock.Lock()
+		finished := t.finished
+		t.lock.Unlock()
+		if finished || quitting {
+			return false
+		}
+		t.confirmQuit = true
+		t.message = "Downloads are in progress. Press q again to cancel them and quit."
+	case "up", "k":
+		t.row--
+	case "down", "j":
+		t.row++
+	case "pgup":
+		t.row -= page
+	case "pgdn":
+		t.row += page
+	case "home", "g":
+		t.row = 0
+	case "end", "G":
+		t.row = t.downloads.length() - 1
+	case "p":
+		t.togglePause()
+	case "c":
+		t.lock.Lock()
+		if !t.finished {
+			t.cancelled, t.paused = true, false
+		}
+		t.lock.Unlock()
+		t.job.cancelAll()
+		t.message = "Cancelling the downloads..."
+	case "r":
+		t.retryFailed()
+	case "f":
+		t.filter = (t.filter + 1) % len(tuiFilters)
+		t.applyFilter()
+	case "s":
+		t.sortOrder = (t.sortOrder + 1) % len(sortOrders)
+		t.downloads.setSortOrder(sortOrders[t.sortOrder])
+	case "/":
+		t.searching = true
+	case "esc":
+		t.query = ""
+		t.applyFilter()
+	}
+	return true
+}
+
+func (t *tui) togglePause() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.finished {
+		return
+	}
+	t.paused = !t.paused
+	if t.paused {
+		t.job.pause()
+		t.message = "Paused. The downloads in progress will start over when resumed."
+	} else {
+		t.job.resume()
+	}
+}
+
+// retryFailed downloads every failed video again.
+func (t *tui) retryFailed() {
+	var failed []int
+	for i, item := range t.job.run.items {
+		if item.getStatus() == statusFailed {
+			failed = append(failed, i)
+		}
+	}
+	if len(failed) == 0 {
+		t.message = "No video failed."
+		return
+	}
+	// Before retrying, since the job may finish again right away.
+	t.lock.Lock()
+	t.finished, t.cancelled = false, false
+	t.lock.Unlock()
+	for _, i := range failed {
+		t.job.retry(i)
+	}
+	t.message = fmt.Sprintf("Retrying %d videos.", len(failed))
+}
+
+func (t *tui) applyFilter() {
+	statuses := map[string]bool{}
+	if status := tuiFilters[t.filter]; status != "" {
+		statuses[status] = true
+	}
+	t.downloads.setFilter(statuses, t.query)
+}
+
+func (t *tui) size() (width, height int, err error) {
+	width, height, err = terminalSize(os.Stdout)
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24, err
+	}
+	return width, height, nil
+}
+
+// draw redraws the whole screen.
+func (t *tui) draw() {
+	width, height, _ := t.size()
+	t.lock.Lock()
+	counters, finished, cancelled, paused := t.counters, t.finished, t.cancelled, t.paused
+	t.lock.Unlock()
+
+	var screen strings.Builder
+	line := func(format string, args ...interface{}) {
+		screen.WriteString(fitLine(fmt.Sprintf(format, args...), width))
+		screen.WriteString("\x1b[K\r\n")
+	}
+
+	line("\x1b[1mTikTok Archiver\x1b[0m")
+	line("Input:   %s", inputsSummary(t.inputs))
+	line("Output:  %s", t.outputDir)
+	line("Options: %s", t.options.summary())
+	line("")
+	line("%s %3.0f%%  %d / %d videos processed%s", progressBar(counters.Fraction, 30), counters.Fraction*100,
+		counters.Completed, counters.Total, countsSummary(counters))
+	speed := humanize.Bytes(uint64(counters.BytesDone))
+	if counters.BytesTotal > 0 {
+		speed += " of about " + humanize.Bytes(uint64(counters.BytesTotal))
+	}
+	switch {
+	case finished && cancelled:
+		line("Cancelled. %s", speed)
+	case finished:
+		line("Finished. %s", speed)
+	case cancelled:
+		line("Cancelling...")
+	case paused:
+		line("Paused. %s", speed)
+	default:
+		speed += fmt.Sprintf(", downloading %s/s", humanize.Bytes(uint64(counters.BytesPerSecond)))
+		if counters.ETA > 0 {
+			speed += fmt.Sprintf(", about %s left", counters.ETA.Round(time.Second))
+		}
+		line("%s", speed)
+	}
+	shown := "Showing all videos"
+	if status := tuiFilters[t.filter]; status != "" {
+		shown = fmt.Sprintf("Showing %s videos", status)
+	}
+	if t.query != "" {
+		shown += fmt.Sprintf(" matching %q", t.query)
+	}
+	line("%s, %s (%d)", shown, strings.ToLower(sortOrders[t.sortOrder]), t.downloads.length())
+	line("%s", strings.Repeat("─", width))
+
+	items := t.downloads.visibleItems()
+	rows := height - tuiHeaderLines - tuiFooterLines
+	if rows < 1 {
+		rows = 1
+	}
+	if t.row >= len(items) {
+		t.row = len(items) - 1
+	}
+	if t.row < 0 {
+		t.row = 0
+	}
+	if t.row < t.scroll {
+		t.scroll = t.row
+	}
+	if t.row >= t.scroll+rows {
+		t.scroll = t.row - rows + 1
+	}
+	nameWidth := 0
+	for _, item := range items {
+		if n := utf8.RuneCountInString(item.Name); n > nameWidth {
+			nameWidth = n
+		}
+	}
+	for r := t.scroll; r < t.scroll+rows; r++ {
+		if r >= len(items) {
+			line("")
+			continue
+		}
+		item := items[r]
+		symbol := statusSymbols[item.Status]
+		text := fmt.Sprintf("%-*s  %-11s  %s", nameWidth, item.Name, item.Status, item.details())
+		if r == t.row {
+			line("\x1b[7m%s %s\x1b[0m", symbol[0], padLine(text, width-2))
+		} else if symbol[1] != "" {
+			line("%s%s\x1b[0m %s", symbol[1], symbol[0], text)
+		} else {
+			line("%s %s", symbol[0], text)
+		}
+	}
+
+	line("%s", strings.Repeat("─", width))
+	switch {
+	case t.searching:
+		screen.WriteString(fitLine("Search: "+t.query+"_  (enter: done, esc: clear)", width))
+	case t.message != "":
+		screen.WriteString(fitLine(t.message, width))
+	default:
+		screen.WriteString(fitLine("↑/↓ scroll  p pause/resume  c cancel  r retry failed  f filter  / search  s sort  q quit", width))
+	}
+	screen.WriteString("\x1b[K\x1b[J")
+	fmt.Print("\x1b[H" + screen.String())
+}
+
+// statusSymbols are the TUI's equivalent of the window's status icons, along with the escape sequence of their color.
+var statusSymbols = map[string][2]string{
+	statusQueued:     {"·", ""},
+	statusInProgress: {"↓", "\x1b[33m"},
+	statusSucceeded:  {"✓", "\x1b[32m"},
+	statusFailed:     {"✗", "\x1b[31m"},
+	statusSkipped:    {"»", "\x1b[34m"},
+	statusCancelled:  {"⊘", "\x1b[2m"},
+}
+
+func progressBar(fraction float64, width int) string {
+	filled := int(fraction * float64(width))
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", width-filled) + "]"
+}
+
+func countsSummary(c progressCounters) string {
+	var parts []string
+	if c.Errors > 0 {
+		parts = append(parts, fmt.Sprintf("\x1b[31m%d errors\x1b[0m", c.Errors))
+	}
+	if c.Skipped > 0 {
+		parts = append(parts, fmt.Sprintf("%d skipped", c.Skipped))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+func inputsSummary(inputs []exportInput) string {
+	if len(inputs) == 0 {
+		return ""
+	}
+	summary := filepath.Base(inputs[0].Path)
+	if inputs[0].Account != "" {
+		summary += fmt.Sprintf(" (%s)", inputs[0].Account)
+	}
+	if len(inputs) > 1 {
+		summary += fmt.Sprintf(" and %d more", len(inputs)-1)
+	}
+	return summary
+}
+
+// summary describes the options in a few words.
+func (o daemonOptions) summary() string {
+	parts := []string{fmt.Sprintf("%d at a time", o.Parallelism), strings.ToLower(o.Order)}
+	if o.SkipExisting {
+		parts = append(parts, fmt.Sprintf("skipping existing videos (%s)", strings.ToLower(o.SkipMode)))
+	}
+	if o.OnlyNew {
+		parts = append(parts, "only new posts")
+	}
+	if o.FileNames != "" {
+		parts = append(parts, "named "+o.FileNames)
+	}
+	if o.WriteSidecars {
+		parts = append(parts, "with details")
+	}
+	if o.Gallery {
+		parts = append(parts, "gallery")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// fitLine cuts text to width columns, not counting escape sequences.
+func fitLine(text string, width int) string {
+	var fitted strings.Builder
+	columns := 0
+	escaped := false
+	for _, r := range text {
+		switch {
+		case r == 0x1b:
+			escaped = true
+		case escaped:
+			if r >= '@' && r <= '~' && r != '[' {
+				escaped = false
+			}
+		default:
+			if columns == width {
+				return fitted.String() + "\x1b[0m"
+			}
+			columns++
+		}
+		fitted.WriteRune(r)
+	}
+	return fitted.String()
+}
+
+// padLine pads text with spaces to width columns, so that highlighting covers the whole line.
+func padLine(text string, width int) string {
+	if n := utf8.RuneCountInString(text); n < width {
+		return text + strings.Repeat(" ", width-n)
+	}
+	return text
+}
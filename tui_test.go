@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		input string
+		key   tuiKey
+		size  int
+	}{
+		{input: "q", key: "q", size: 1},
+		{input: "jk", key: "j", size: 1},
+		{input: "é!", key: "é", size: 2},
+		{input: "\r", key: "enter", size: 1},
+		{input: "\x7f", key: "backspace", size: 1},
+		{input: "\x03", key: "ctrl+c", size: 1},
+		{input: "\x1b[A", key: "up", size: 3},
+		{input: "\x1b[Bj", key: "down", size: 3},
+		{input: "\x1b[5~", key: "pgup", size: 4},
+		{input: "\x1bOF", key: "end", size: 3},
+		// Keys the TUI doesn't use are skipped whole, parameters and all.
+		{input: "\x1b[3~q", key: "", size: 4},
+		{input: "\x1b[1;5Cq", key: "", size: 6},
+		{input: "\x1bOPq", key: "", size: 3},
+		// Esc followed by anything but a sequence is Esc on its own.
+		{input: "\x1bq", key: "esc", size: 1},
+		{input: "\x1b\x1b[A", key: "esc", size: 1},
+		{input: "\x1b[\x03", key: "esc", size: 1},
+		// Unfinished keys need more input.
+		{input: "\x1b", size: 0},
+		{input: "\x1b[", size: 0},
+		{input: "\x1b[1;5", size: 0},
+		{input: "\x1bO", size: 0},
+		{input: "é"[:1], size: 0},
+	}
+	for _, test := range tests {
+		key, size := parseKey([]byte(test.input))
+		if key != test.key || size != test.size {
+			t.Errorf("parseKey(%q) = %q, %d, want %q, %d", test.input, key, size, test.key, test.size)
+		}
+	}
+}
+
+// TestParseKeys reads keys the way readKeys does, from reads that may split them.
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		reads []string
+		final bool // Whether nothing comes after the last read.
+		keys  []tuiKey
+		rest  string
+	}{
+		{reads: []string{"jk\r"}, keys: []tuiKey{"j", "k", "enter"}},
+		{reads: []string{"\x1b[A\x1b[B"}, keys: []tuiKey{"up", "down"}},
+		{reads: []string{"j\x1b", "[A"}, keys: []tuiKey{"j", "up"}},
+		{reads: []string{"\x1b[", "6", "~"}, keys: []tuiKey{"pgdn"}},
+		{reads: []string{"\x1b[1;", "5Dq"}, keys: []tuiKey{"q"}},
+		{reads: []string{"é"[:1], "é"[1:]}, keys: []tuiKey{"é"}},
+		{reads: []string{"j\x1b[5"}, keys: []tuiKey{"j"}, rest: "\x1b[5"},
+		{reads: []string{"\x1b"}, final: true, keys: []tuiKey{"esc"}},
+		{reads: []string{"/\x1b[1;"}, final: true, keys: []tuiKey{"/", "esc", "[", "1", ";"}},
+		{reads: []string{"é"[:1]}, final: true},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%q", test.reads), func(t *testing.T) {
+			var keys []tuiKey
+			var pending []byte
+			for i, read := range test.reads {
+				var parsed []tuiKey
+				parsed, pending = parseKeys(append(pending, read...), test.final && i == len(test.reads)-1)
+				keys = append(keys, parsed...)
+			}
+			if fmt.Sprint(keys) != fmt.Sprint(test.keys) || string(pending) != test.rest {
+				t.Errorf("keys are %q, leaving %q, want %q, leaving %q", keys, pending, test.keys, test.rest)
+			}
+		})
+	}
+}
+
+func TestFitLine(t *testing.T) {
+	const red, reset = "\x1b[31m", "\x1b[0m"
+	tests := []struct {
+		text  string
+		width int
+		want  string
+	}{
+		{text: "hello", width: 10, want: "hello"},
+		{text: "hello", width: 5, want: "hello"},
+		{text: "hello world", width: 5, want: "hello" + reset},
+		{text: "héllo wörld", width: 7, want: "héllo w" + reset},
+		{text: "", width: 5, want: ""},
+		{text: "hello", width: 0, want: reset},
+		// Escape sequences don't take any columns, and are never cut in the middle.
+		{text: red + "hello" + reset, width: 5, want: red + "hello" + reset},
+		{text: red + "hello world" + reset, width: 5, want: red + "hello" + reset},
+		{text: "ab" + red + "cd" + reset + "ef", width: 3, want: "ab" + red + "c" + reset},
+		{text: "ab" + red + "cd", width: 2, want: "ab" + red + reset},
+		{text: "\x1b[1;38;5;208mbold", width: 2, want: "\x1b[1;38;5;208mbo" + reset},
+	}
+	for _, test := range tests {
+		got := fitLine(test.text, test.width)
+		if got != test.want {
+			t.Errorf("fitLine(%q, %d) = %q, want %q", test.text, test.width, got, test.want)
+		}
+		if visible := stripEscapes(got); len([]rune(visible)) > test.width {
+			t.Errorf("fitLine(%q, %d) shows %q, which is wider than %d", test.text, test.width, visible, test.width)
+		}
+	}
+}
+
+// stripEscapes removes the CSI sequences of text, leaving what the terminal shows.
+func stripEscapes(text string) string {
+	var visible strings.Builder
+	for len(text) > 0 {
+		if strings.HasPrefix(text, "\x1b[") {
+			end := strings.IndexFunc(text[2:], func(r rune) bool { return r >= '@' && r <= '~' })
+			text = text[2+end+1:]
+			continue
+		}
+		visible.WriteByte(text[0])
+		text = text[1:]
+	}
+	return visible.String()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// corruptFile is a video in the output directory that failed verification.
+type corruptFile struct {
+	Name   string
+	Reason string
+}
+
+// findCorruptVideos verifies every MP4 file in dir and its account folders, returning how many were checked and which
+// of them are corrupt.
+func findCorruptVideos(dir string) (int, []corruptFile, error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return 0, nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if strings.EqualFold(filepath.Ext(name), ".mp4") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	checked := 0
+	var corrupt []corruptFile
+	for _, name := range names {
+		checked++
+		if err := verifyMP4(filepath.Join(dir, filepath.FromSlash(name)), 0); err != nil {
+			corrupt = append(corrupt, corruptFile{Name: name, Reason: err.Error()})
+		}
+	}
+	return checked, corrupt, nil
+}
+
+// verifyArchive checks every video in the output directory, and offers to download the corrupt ones again.
+func verifyArchive(appState *appState) {
+	outputDir, _ := appState.outputDir.Get()
+	if outputDir == "" {
+		dialog.ShowError(fmt.Errorf("You must select a folder to verify."), appState.window)
+		return
+	}
+	progress := dialog.NewProgressInfinite("Verify archive", fmt.Sprintf("Checking the videos in %s...", outputDir), appState.window)
+	progress.Show()
+	go func() {
+		checked, corrupt, err := findCorruptVideos(outputDir)
+		progress.Hide()
+		if err != nil {
+			logger.Errorf("Failed to verify archive: %v\n", err)
+			dialog.ShowError(err, appState.window)
+			return
+		}
+		logger.Infof("Verified %d videos in %s, %d are corrupt\n", checked, outputDir, len(corrupt))
+		for _, file := range corrupt {
+			logger.Warnf("%s is corrupt: %s\n", file.Name, file.Reason)
+		}
+		if len(corrupt) == 0 {
+			dialog.ShowInformation("Verify archive", fmt.Sprintf("All %d videos are intact.", checked), appState.window)
+			return
+		}
+
+		// Corrupt files can only be downloaded again if they're in the input file.
+		var requeue []VideoLink
+		if links, _, err := readExports(appState.inputs()); err == nil {
+			template := appState.jobOptions().fileNames
+			isCorrupt := map[string]bool{}
+			for _, file := range corrupt {
+				isCorrupt[file.Name] = true
+			}
+			for _, link := range links {
+				if isCorrupt[videoName(link, template)] {
+					requeue = append(requeue, link)
+				}
+			}
+		}
+
+		list := widget.NewList(
+			func() int {
+				return len(corrupt)
+			},
+			func() fyne.CanvasObject {
+				return widget.NewLabel("")
+			},
+			func(id widget.ListItemID, obj fyne.CanvasObject) {
+				obj.(*widget.Label).SetText(fmt.Sprintf("%s: %s", corrupt[id].Name, corrupt[id].Reason))
+			},
+		)
+		message := widget.NewLabel(fmt.Sprintf("%d of %d videos are corrupt.", len(corrupt), checked))
+		if len(requeue) < len(corrupt) {
+			message.SetText(message.Text + fmt.Sprintf(" %d of them aren't in the input file, and can't be downloaded again.", len(corrupt)-len(requeue)))
+		}
+		message.Wrapping = fyne.TextWrapWord
+		content := container.NewBorder(message, nil, nil, nil, list)
+		if len(requeue) == 0 {
+			d := dialog.NewCustom("Verify archive", "Close", content, appState.window)
+			d.Resize(fyne.NewSize(600, 400))
+			d.Show()
+			return
+		}
+		d := dialog.NewCustomConfirm("Verify archive", fmt.Sprintf("Download %d again", len(requeue)), "Close", content, func(ok bool) {
+			if !ok {
+				return
+			}
+			startDownloads(appState, func() ([]VideoLink, jobOptions, error) {
+				options := appState.jobOptions()
+				options.skipExisting = false
+				return requeue, options, nil
+			})
+		}, appState.window)
+		d.Resize(fyne.NewSize(600, 400))
+		d.Show()
+	}()
+}